@@ -1,46 +1,129 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/yebrai/stream-snatchet/gui"
+	"github.com/yebrai/stream-snatchet/internal/clip"
 	"github.com/yebrai/stream-snatchet/internal/downloader"
 	"github.com/yebrai/stream-snatchet/internal/extractor"
+	"github.com/yebrai/stream-snatchet/internal/info"
 	"github.com/yebrai/stream-snatchet/internal/merger"
+	"github.com/yebrai/stream-snatchet/internal/session"
 	"github.com/yebrai/stream-snatchet/pkg/models"
 )
 
-var config *models.Config
+var (
+	config      *models.Config
+	headerFlags []string
+	listSources bool
+	infoJSON    bool
+	replayDir   string
+)
 
 var rootCmd = &cobra.Command{
 	Use:   "stream-snatchet [iframe-url]",
 	Short: "Download streaming videos from iframe URLs",
 	Long: `Stream Snatchet is a tool to download streaming videos from iframe URLs.
 It extracts HLS manifests, downloads segments concurrently, and merges them into a single video file.
+A manifest or media file URL, a HAR file captured with browser devtools, or a saved HTML page
+(a file, or - for stdin, with --base-url) can be given instead of a page URL.
 
 Examples:
   stream-snatchet "https://example.com/iframe/video"
+  stream-snatchet "https://cdn.example.com/hls/master.m3u8"
+  stream-snatchet capture.har
+  curl -s "https://example.com/embed/1" | stream-snatchet --base-url "https://example.com/embed/1" -
   stream-snatchet --gui
   stream-snatchet --output ./videos --quality best "https://example.com/iframe/video"`,
 	Args: cobra.MaximumNArgs(1),
 	RunE: runDownload,
 }
 
+var infoCmd = &cobra.Command{
+	Use:   "info [iframe-url]",
+	Short: "Print what extraction finds about a stream without downloading it",
+	Long: `Info extracts a stream like a download would and prints its title, manifest URL,
+variants, audio and subtitle tracks, segment count, duration, encryption and the
+headers requests are sent with. The input can be anything a download accepts.
+
+With --json the output is a JSON object whose layout stays stable for scripts.
+With --replay, a directory written by --dump-pages is extracted again offline,
+answering every request with the recorded response.
+
+Examples:
+  stream-snatchet info "https://example.com/iframe/video"
+  stream-snatchet info --json --audio-lang es capture.har
+  stream-snatchet info --dump-pages ./dump "https://example.com/iframe/video"
+  stream-snatchet info --replay ./dump`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runInfo,
+}
+
+var extractorsCmd = &cobra.Command{
+	Use:   "extractors",
+	Short: "List registered site extractors",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, site := range extractor.Registered() {
+			fmt.Println(site.Name())
+		}
+		fmt.Println("generic (fallback)")
+	},
+}
+
 func init() {
 	config = models.DefaultConfig()
 
+	rootCmd.AddCommand(extractorsCmd)
+	rootCmd.AddCommand(infoCmd)
+
 	rootCmd.Flags().StringVarP(&config.OutputDir, "output", "o", config.OutputDir, "Output directory for downloaded videos")
-	rootCmd.Flags().StringVarP(&config.Quality, "quality", "q", config.Quality, "Video quality preference (best, worst, or specific)")
+	rootCmd.Flags().DurationVar(&config.RecordDuration, "duration", config.RecordDuration, "Stop recording a live stream after this much media, e.g. 30m or 1h30m (0 records until the stream ends)")
+	rootCmd.Flags().StringVar(&config.ClipStart, "start", config.ClipStart, "Download from this time: seconds, 1m30s, hh:mm:ss or an RFC 3339 date matched against EXT-X-PROGRAM-DATE-TIME")
+	rootCmd.Flags().StringVar(&config.ClipEnd, "end", config.ClipEnd, "Download up to this time, in the same formats as --start")
+	rootCmd.Flags().StringVar(&config.SubtitleFormat, "sub-format", config.SubtitleFormat, "Subtitle file format: vtt or srt")
+	rootCmd.Flags().StringVar(&config.OutputFormat, "format", config.OutputFormat, "Output container: mp4 or mkv")
+	rootCmd.Flags().BoolVar(&listSources, "list-sources", false, "List the manifests found on the page, probed for format, duration and quality, and exit")
 	rootCmd.Flags().IntVarP(&config.MaxConcurrency, "concurrent", "c", config.MaxConcurrency, "Maximum concurrent downloads")
 	rootCmd.Flags().IntVarP(&config.RetryAttempts, "retries", "r", config.RetryAttempts, "Number of retry attempts for failed downloads")
-	rootCmd.Flags().IntVarP(&config.TimeoutSeconds, "timeout", "t", config.TimeoutSeconds, "Timeout in seconds for HTTP requests")
-	rootCmd.Flags().StringVar(&config.UserAgent, "user-agent", config.UserAgent, "User agent string for HTTP requests")
 	rootCmd.Flags().BoolVar(&config.EnableGUI, "gui", config.EnableGUI, "Launch GUI mode")
-	rootCmd.Flags().BoolVarP(&config.Verbose, "verbose", "v", config.Verbose, "Enable verbose output")
+	addExtractionFlags(rootCmd.Flags())
+
+	infoCmd.Flags().BoolVar(&infoJSON, "json", false, "Print the stream information as JSON (disables --verbose)")
+	infoCmd.Flags().StringVar(&replayDir, "replay", "", "Extract offline from a directory written by --dump-pages instead of the input")
+	addExtractionFlags(infoCmd.Flags())
+}
+
+// addExtractionFlags adds the flags that affect extraction, shared by the
+// download and the info command.
+func addExtractionFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&config.Title, "title", config.Title, "Override the video title used for the output filename")
+	flags.StringVarP(&config.Quality, "quality", "q", config.Quality, "Video quality preference (best, worst, a height like 720p, or a max bandwidth like 2500k)")
+	flags.StringVar(&config.AudioLang, "audio-lang", config.AudioLang, "Preferred audio language or rendition name for streams with separate audio tracks, e.g. en or es-419")
+	flags.BoolVar(&config.SkipAds, "skip-ads", config.SkipAds, "Drop ad breaks: CUE-OUT/CUE-IN and SCTE-35 marked segments and discontinuities served from another host")
+	flags.StringVar(&config.SubtitleMode, "subs", config.SubtitleMode, "Subtitle handling: none, sidecar (files next to the video) or embed (soft subtitles)")
+	flags.StringSliceVar(&config.SubtitleLangs, "sub-lang", config.SubtitleLangs, "Subtitle languages or track names to download, e.g. en,es (all tracks when empty)")
+	flags.IntVar(&config.SourceIndex, "source", config.SourceIndex, "Download the Nth manifest listed by --list-sources instead of the longest one")
+	flags.IntVar(&config.MaxIframeDepth, "max-depth", config.MaxIframeDepth, "Maximum number of nested iframes and redirects to follow")
+	flags.IntVarP(&config.TimeoutSeconds, "timeout", "t", config.TimeoutSeconds, "Timeout in seconds for HTTP requests")
+	flags.StringVar(&config.UserAgent, "user-agent", config.UserAgent, "User agent string for HTTP requests")
+	flags.StringArrayVarP(&headerFlags, "header", "H", nil, "Extra HTTP header as \"Key: Value\" (repeatable)")
+	flags.StringVar(&config.Referer, "referer", config.Referer, "Referer sent with every request (Origin is derived from it)")
+	flags.StringVar(&config.Cookies, "cookie", config.Cookies, "Cookie header sent with every request, e.g. \"name=value; other=value\"")
+	flags.StringVar(&config.CookiesFile, "cookies-file", config.CookiesFile, "Netscape cookies.txt file to load cookies from")
+	flags.StringVar(&config.BaseURL, "base-url", config.BaseURL, "URL a local HTML file or a page read from stdin (-) was loaded from, used to resolve its links")
+	flags.StringVar(&config.DumpDir, "dump-pages", config.DumpDir, "Write every page, manifest and response header fetched during extraction into this directory, for replaying with info --replay")
+	flags.StringVar(&config.Proxy, "proxy", config.Proxy, "Proxy for every request: http://, https://, socks5:// or socks5h:// URL, with user:pass@ for credentials")
+	flags.BoolVarP(&config.Verbose, "verbose", "v", config.Verbose, "Enable verbose output")
 }
 
 func main() {
@@ -51,12 +134,23 @@ func main() {
 }
 
 func runDownload(cmd *cobra.Command, args []string) error {
+	if err := applyHeaderFlags(); err != nil {
+		return err
+	}
+	if err := validateFormats(); err != nil {
+		return err
+	}
+	clipRange, err := clip.ParseRange(config.ClipStart, config.ClipEnd)
+	if err != nil {
+		return err
+	}
+
 	if config.EnableGUI {
 		return gui.LaunchGUI(config)
 	}
 
 	if len(args) == 0 {
-		return fmt.Errorf("iframe URL is required when not using GUI mode")
+		return fmt.Errorf("an iframe URL, HAR file, HTML file or - is required when not using GUI mode")
 	}
 
 	iframeURL := args[0]
@@ -73,20 +167,59 @@ func runDownload(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	ext := extractor.New(config)
+	sess, err := newSession()
+	if err != nil {
+		return err
+	}
+	ext := extractor.NewWithSession(config, sess)
 
 	if config.Verbose {
 		fmt.Println("Extracting stream information...")
 	}
 
-	streamInfo, err := ext.ExtractFromIframe(iframeURL)
+	streamInfo, err := ext.Extract(iframeURL)
 	if err != nil {
 		return fmt.Errorf("failed to extract stream info: %w", err)
 	}
 
+	if listSources {
+		printSources(streamInfo)
+		return nil
+	}
+
+	if err := clip.Apply(streamInfo, clipRange); err != nil {
+		return fmt.Errorf("failed to select time range: %w", err)
+	}
+
 	if config.Verbose {
+		if len(streamInfo.Variants) > 0 {
+			fmt.Printf("Found %d variants, selected %s\n", len(streamInfo.Variants), streamInfo.Quality)
+		}
+		fmt.Printf("Title: %s\n", streamInfo.Title)
 		fmt.Printf("Found %d segments\n", len(streamInfo.Segments))
-		fmt.Printf("Estimated duration: %v\n", streamInfo.Duration)
+		if len(streamInfo.AudioTracks) > 1 {
+			fmt.Printf("Found %d audio tracks\n", len(streamInfo.AudioTracks))
+		}
+		if streamInfo.Audio != nil {
+			fmt.Printf("Separate audio track: %s %s (%d segments)\n", streamInfo.Audio.Language, streamInfo.Audio.Name, len(streamInfo.Audio.Segments))
+		}
+		for _, subtitle := range streamInfo.Subtitles {
+			if len(subtitle.Segments) > 0 {
+				fmt.Printf("Subtitle track: %s %s (%d segments)\n", subtitle.Language, subtitle.Name, len(subtitle.Segments))
+			} else {
+				fmt.Printf("Subtitle track: %s %s (not downloaded)\n", subtitle.Language, subtitle.Name)
+			}
+		}
+		if streamInfo.IsLive {
+			fmt.Printf("Live stream, window of %v\n", streamInfo.Duration)
+		} else if streamInfo.ClipEnd > 0 {
+			fmt.Printf("Clipping %v to %v (%v)\n", streamInfo.ClipStart, streamInfo.ClipEnd, streamInfo.Duration)
+		} else {
+			fmt.Printf("Estimated duration: %v\n", streamInfo.Duration)
+		}
+		for i, page := range streamInfo.Chain {
+			fmt.Printf("Page %d: %s\n", i+1, page)
+		}
 		fmt.Printf("Manifest URL: %s\n", streamInfo.ManifestURL)
 		fmt.Println()
 	}
@@ -97,14 +230,20 @@ func runDownload(cmd *cobra.Command, args []string) error {
 	}
 	defer os.RemoveAll(tempDir)
 
-	dl := downloader.New(config)
+	dl := downloader.NewWithSession(config, sess)
 
-	if config.Verbose {
-		fmt.Println("Starting segment downloads...")
-	}
+	if streamInfo.IsLive {
+		if err := recordLive(ext, dl, streamInfo, tempDir); err != nil {
+			return err
+		}
+	} else {
+		if config.Verbose {
+			fmt.Println("Starting segment downloads...")
+		}
 
-	if err := dl.DownloadSegments(streamInfo, tempDir); err != nil {
-		return fmt.Errorf("failed to download segments: %w", err)
+		if err := dl.DownloadSegments(streamInfo, tempDir); err != nil {
+			return fmt.Errorf("failed to download segments: %w", err)
+		}
 	}
 
 	mrg := merger.New(config)
@@ -127,3 +266,149 @@ func runDownload(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+func runInfo(cmd *cobra.Command, args []string) error {
+	if err := applyHeaderFlags(); err != nil {
+		return err
+	}
+	if err := validateFormats(); err != nil {
+		return err
+	}
+
+	switch {
+	case replayDir != "" && len(args) > 0:
+		return fmt.Errorf("--replay extracts the input recorded in the dump, no argument is needed")
+	case replayDir != "" && config.DumpDir != "":
+		return fmt.Errorf("--replay and --dump-pages can't be combined")
+	case replayDir == "" && len(args) == 0:
+		return fmt.Errorf("an iframe URL, HAR file, HTML file or - is required")
+	}
+
+	// Verbose messages go to stdout, where they would break the JSON.
+	if infoJSON {
+		config.Verbose = false
+	}
+
+	sess, err := newSession()
+	if err != nil {
+		return err
+	}
+	ext := extractor.NewWithSession(config, sess)
+
+	var streamInfo *models.StreamInfo
+	if replayDir != "" {
+		streamInfo, err = ext.Replay(replayDir)
+	} else {
+		streamInfo, err = ext.Extract(args[0])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract stream info: %w", err)
+	}
+
+	summary := info.Summarize(streamInfo)
+	if infoJSON {
+		return info.WriteJSON(os.Stdout, summary)
+	}
+	return info.WriteText(os.Stdout, summary)
+}
+
+// newSession creates the session shared by extraction and downloads, with
+// the cookies of --cookies-file loaded.
+func newSession() (*session.Session, error) {
+	sess := session.New(config)
+	if config.CookiesFile != "" {
+		if err := sess.LoadCookiesFile(config.CookiesFile); err != nil {
+			return nil, err
+		}
+	}
+	return sess, nil
+}
+
+// recordLive records a live stream until it ends, the --duration limit is
+// reached or the user presses Ctrl-C, after which the recorded part is merged
+// as usual.
+func recordLive(ext *extractor.Extractor, dl *downloader.Downloader, streamInfo *models.StreamInfo, tempDir string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if config.RecordDuration > 0 {
+		fmt.Printf("Recording live stream for %v, press Ctrl-C to stop early...\n", config.RecordDuration)
+	} else {
+		fmt.Println("Recording live stream, press Ctrl-C to stop...")
+	}
+
+	refresh := func() (*models.StreamInfo, error) {
+		return ext.RefreshPlaylist(streamInfo)
+	}
+	if err := dl.RecordLive(ctx, streamInfo, tempDir, refresh, config.RecordDuration); err != nil {
+		return fmt.Errorf("failed to record live stream: %w", err)
+	}
+
+	if config.Verbose {
+		fmt.Printf("Recorded %d segments (%v)\n", len(streamInfo.Segments), streamInfo.Duration.Round(time.Second))
+	}
+	return nil
+}
+
+func applyHeaderFlags() error {
+	if len(headerFlags) == 0 {
+		return nil
+	}
+
+	if config.Headers == nil {
+		config.Headers = make(map[string]string)
+	}
+	for _, line := range headerFlags {
+		key, value, err := session.ParseHeader(line)
+		if err != nil {
+			return err
+		}
+		config.Headers[key] = value
+	}
+	return nil
+}
+
+// printSources lists the manifest candidates of a page, marking the one that
+// would be downloaded.
+func printSources(streamInfo *models.StreamInfo) {
+	if len(streamInfo.Candidates) == 0 {
+		fmt.Printf("* 1. %s\n", streamInfo.ManifestURL)
+		return
+	}
+
+	for i, candidate := range streamInfo.Candidates {
+		marker := " "
+		if candidate.URL == streamInfo.ManifestURL {
+			marker = "*"
+		}
+		fmt.Printf("%s %d. %s\n", marker, i+1, extractor.DescribeCandidate(candidate))
+	}
+}
+
+func validateFormats() error {
+	switch config.SubtitleMode {
+	case "none", "sidecar", "embed":
+	default:
+		return fmt.Errorf("invalid --subs %q, expected none, sidecar or embed", config.SubtitleMode)
+	}
+	if config.SubtitleFormat != "vtt" && config.SubtitleFormat != "srt" {
+		return fmt.Errorf("invalid --sub-format %q, expected vtt or srt", config.SubtitleFormat)
+	}
+	if config.OutputFormat != "mp4" && config.OutputFormat != "mkv" {
+		return fmt.Errorf("invalid --format %q, expected mp4 or mkv", config.OutputFormat)
+	}
+	if config.SourceIndex < 0 {
+		return fmt.Errorf("invalid --source %d, expected a number from --list-sources", config.SourceIndex)
+	}
+	if config.Referer != "" {
+		if err := session.ValidateReferer(config.Referer); err != nil {
+			return err
+		}
+	}
+	if config.Proxy != "" {
+		if _, err := session.ParseProxy(config.Proxy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
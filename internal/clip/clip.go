@@ -0,0 +1,237 @@
+// Package clip restricts a stream to a time range, so that only the
+// segments overlapping it are downloaded and the merger can trim the result
+// to the range.
+package clip
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// Point is a position in a stream: either an offset from its start or, when
+// WallClock is set, a date matched against EXT-X-PROGRAM-DATE-TIME.
+type Point struct {
+	Offset    time.Duration
+	WallClock time.Time
+}
+
+// Range is a time range of a stream. A zero Start is the start of the
+// stream and a zero End its end.
+type Range struct {
+	Start Point
+	End   Point
+}
+
+// IsZero reports whether the point was not given.
+func (p Point) IsZero() bool {
+	return p.Offset == 0 && p.WallClock.IsZero()
+}
+
+// IsZero reports whether the range covers the whole stream.
+func (r Range) IsZero() bool {
+	return r.Start.IsZero() && r.End.IsZero()
+}
+
+// ParsePoint parses an offset such as "90", "1m30s", "1:30" or
+// "01:02:03.5", or an RFC 3339 wall clock time such as
+// "2024-05-01T18:30:00Z". An empty string is the zero Point.
+func ParsePoint(s string) (Point, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Point{}, nil
+	}
+
+	if strings.Contains(s, "T") && strings.Count(s, "-") >= 2 {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid wall clock time %q, expected RFC 3339 such as 2024-05-01T18:30:00Z", s)
+		}
+		return Point{WallClock: t}, nil
+	}
+
+	offset, err := parseOffset(s)
+	if err != nil || offset < 0 {
+		return Point{}, fmt.Errorf("invalid time %q, expected seconds, a duration such as 1m30s or hh:mm:ss", s)
+	}
+	return Point{Offset: offset}, nil
+}
+
+// ParseRange parses the start and end of a range with ParsePoint.
+func ParseRange(start, end string) (Range, error) {
+	var r Range
+	var err error
+	if r.Start, err = ParsePoint(start); err != nil {
+		return Range{}, fmt.Errorf("start: %w", err)
+	}
+	if r.End, err = ParsePoint(end); err != nil {
+		return Range{}, fmt.Errorf("end: %w", err)
+	}
+	return r, nil
+}
+
+func parseOffset(s string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	if !strings.Contains(s, ":") {
+		return time.ParseDuration(s)
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("too many fields")
+	}
+
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil {
+		return 0, err
+	}
+	total := time.Duration(seconds * float64(time.Second))
+
+	unit := time.Minute
+	for i := len(parts) - 2; i >= 0; i-- {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, err
+		}
+		total += time.Duration(n) * unit
+		unit *= 60
+	}
+	return total, nil
+}
+
+// Apply drops the video and audio segments that don't overlap the range,
+// using the cumulative EXTINF offsets of the segments, and records the
+// resolved range in streamInfo.ClipStart and ClipEnd for the merger to trim
+// to. Subtitle tracks are kept whole; the merger shifts their cues instead.
+//
+// Progressive files have no segment timeline, so they are downloaded whole
+// and only trimmed; ClipEnd stays zero when no end was given.
+func Apply(streamInfo *models.StreamInfo, r Range) error {
+	if r.IsZero() {
+		return nil
+	}
+	if streamInfo.IsLive {
+		return fmt.Errorf("time ranges are not supported for live streams, use a recording duration instead")
+	}
+	if streamInfo.Format == "progressive" {
+		return applyProgressive(streamInfo, r)
+	}
+
+	start, err := resolve(r.Start, streamInfo.Segments)
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	end, err := resolve(r.End, streamInfo.Segments)
+	if err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+
+	total := playlistDuration(streamInfo.Segments)
+	if r.End.IsZero() || end > total {
+		end = total
+	}
+	if start >= end {
+		return fmt.Errorf("the range %v-%v is empty, the stream is %v long", start, end, total.Round(time.Second))
+	}
+
+	streamInfo.Segments, streamInfo.InitSegments = selectSegments(streamInfo.Segments, streamInfo.InitSegments, start, end)
+	if streamInfo.Audio != nil {
+		audio := *streamInfo.Audio
+		audio.Segments, audio.InitSegments = selectSegments(audio.Segments, audio.InitSegments, start, end)
+		streamInfo.Audio = &audio
+	}
+
+	streamInfo.ClipStart = start
+	streamInfo.ClipEnd = end
+	streamInfo.Duration = end - start
+	return nil
+}
+
+func applyProgressive(streamInfo *models.StreamInfo, r Range) error {
+	if !r.Start.WallClock.IsZero() || !r.End.WallClock.IsZero() {
+		return fmt.Errorf("wall clock times need EXT-X-PROGRAM-DATE-TIME, which media files don't have")
+	}
+	if !r.End.IsZero() && r.Start.Offset >= r.End.Offset {
+		return fmt.Errorf("the range %v-%v is empty", r.Start.Offset, r.End.Offset)
+	}
+
+	streamInfo.ClipStart = r.Start.Offset
+	streamInfo.ClipEnd = r.End.Offset
+	if !r.End.IsZero() {
+		streamInfo.Duration = r.End.Offset - r.Start.Offset
+	}
+	return nil
+}
+
+// resolve turns a point into an offset from the start of the stream. Wall
+// clock times are looked up in the program date times of the segments.
+func resolve(p Point, segments []models.Segment) (time.Duration, error) {
+	if p.WallClock.IsZero() {
+		return p.Offset, nil
+	}
+
+	var last *models.Segment
+	for i := range segments {
+		segment := &segments[i]
+		if segment.ProgramDateTime.IsZero() {
+			continue
+		}
+		if last == nil && p.WallClock.Before(segment.ProgramDateTime) {
+			return 0, nil
+		}
+		last = segment
+
+		end := segment.ProgramDateTime.Add(seconds(segment.Duration))
+		if p.WallClock.Before(end) {
+			return seconds(segment.Offset) + p.WallClock.Sub(segment.ProgramDateTime), nil
+		}
+	}
+
+	if last == nil {
+		return 0, fmt.Errorf("the playlist has no EXT-X-PROGRAM-DATE-TIME to match %s against", p.WallClock.Format(time.RFC3339))
+	}
+	return playlistDuration(segments), nil
+}
+
+// selectSegments returns the segments overlapping [start, end) and the init
+// segments they use.
+func selectSegments(segments, initSegments []models.Segment, start, end time.Duration) ([]models.Segment, []models.Segment) {
+	var selected []models.Segment
+	usedInits := make(map[string]bool)
+
+	for _, segment := range segments {
+		segmentStart := seconds(segment.Offset)
+		segmentEnd := segmentStart + seconds(segment.Duration)
+		if segmentEnd <= start || segmentStart >= end {
+			continue
+		}
+		selected = append(selected, segment)
+		usedInits[segment.InitFilename] = true
+	}
+
+	var selectedInits []models.Segment
+	for _, init := range initSegments {
+		if usedInits[init.Filename] {
+			selectedInits = append(selectedInits, init)
+		}
+	}
+
+	return selected, selectedInits
+}
+
+func playlistDuration(segments []models.Segment) time.Duration {
+	if len(segments) == 0 {
+		return 0
+	}
+	last := segments[len(segments)-1]
+	return seconds(last.Offset + last.Duration)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
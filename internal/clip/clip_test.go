@@ -0,0 +1,184 @@
+package clip
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+func TestParsePoint(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Point
+		wantErr  bool
+	}{
+		{input: "", expected: Point{}},
+		{input: "90", expected: Point{Offset: 90 * time.Second}},
+		{input: "12.5", expected: Point{Offset: 12500 * time.Millisecond}},
+		{input: "1m30s", expected: Point{Offset: 90 * time.Second}},
+		{input: "1:30", expected: Point{Offset: 90 * time.Second}},
+		{input: "01:02:03.5", expected: Point{Offset: time.Hour + 2*time.Minute + 3500*time.Millisecond}},
+		{input: "2024-05-01T18:30:00Z", expected: Point{WallClock: time.Date(2024, 5, 1, 18, 30, 0, 0, time.UTC)}},
+		{input: "2024-05-01T18:30:00", wantErr: true},
+		{input: "-5", wantErr: true},
+		{input: "1:2:3:4", wantErr: true},
+		{input: "soon", wantErr: true},
+	}
+
+	for _, test := range tests {
+		point, err := ParsePoint(test.input)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParsePoint(%q): expected an error, got %+v", test.input, point)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePoint(%q): unexpected error: %v", test.input, err)
+			continue
+		}
+		if point.Offset != test.expected.Offset || !point.WallClock.Equal(test.expected.WallClock) {
+			t.Errorf("ParsePoint(%q) = %+v, expected %+v", test.input, point, test.expected)
+		}
+	}
+}
+
+func testStream() *models.StreamInfo {
+	start := time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)
+
+	streamInfo := &models.StreamInfo{
+		InitSegments: []models.Segment{{Filename: "init_00.mp4"}, {Filename: "init_01.mp4"}},
+		Audio:        &models.Track{},
+	}
+	for i := 0; i < 10; i++ {
+		init := "init_00.mp4"
+		if i >= 5 {
+			init = "init_01.mp4"
+		}
+		streamInfo.Segments = append(streamInfo.Segments, models.Segment{
+			Index:           i,
+			Duration:        6,
+			Offset:          float64(i * 6),
+			ProgramDateTime: start.Add(time.Duration(i*6) * time.Second),
+			InitFilename:    init,
+		})
+	}
+	// Audio segments don't line up with the video ones.
+	for i := 0; i < 15; i++ {
+		streamInfo.Audio.Segments = append(streamInfo.Audio.Segments, models.Segment{
+			Index:    i,
+			Duration: 4,
+			Offset:   float64(i * 4),
+		})
+	}
+	return streamInfo
+}
+
+func TestApply(t *testing.T) {
+	streamInfo := testStream()
+
+	r, err := ParseRange("0:10", "25")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := Apply(streamInfo, r); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(streamInfo.Segments) != 4 || streamInfo.Segments[0].Index != 1 || streamInfo.Segments[3].Index != 4 {
+		t.Errorf("Expected video segments 1-4, got %+v", streamInfo.Segments)
+	}
+	if len(streamInfo.InitSegments) != 1 || streamInfo.InitSegments[0].Filename != "init_00.mp4" {
+		t.Errorf("Expected only the first init segment, got %+v", streamInfo.InitSegments)
+	}
+	if len(streamInfo.Audio.Segments) != 5 || streamInfo.Audio.Segments[0].Index != 2 || streamInfo.Audio.Segments[4].Index != 6 {
+		t.Errorf("Expected audio segments 2-6, got %+v", streamInfo.Audio.Segments)
+	}
+	if streamInfo.ClipStart != 10*time.Second || streamInfo.ClipEnd != 25*time.Second || streamInfo.Duration != 15*time.Second {
+		t.Errorf("Unexpected clip range %v-%v (%v)", streamInfo.ClipStart, streamInfo.ClipEnd, streamInfo.Duration)
+	}
+}
+
+func TestApplyWallClock(t *testing.T) {
+	streamInfo := testStream()
+
+	r, err := ParseRange("2024-05-01T18:00:45Z", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := Apply(streamInfo, r); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(streamInfo.Segments) != 3 || streamInfo.Segments[0].Index != 7 {
+		t.Errorf("Expected video segments 7-9, got %+v", streamInfo.Segments)
+	}
+	if streamInfo.ClipStart != 45*time.Second || streamInfo.ClipEnd != 60*time.Second {
+		t.Errorf("Unexpected clip range %v-%v", streamInfo.ClipStart, streamInfo.ClipEnd)
+	}
+}
+
+func TestApplyProgressive(t *testing.T) {
+	streamInfo := &models.StreamInfo{
+		Format:   "progressive",
+		Segments: []models.Segment{{ByteLength: 8}, {ByteOffset: 8, ByteLength: 8}},
+	}
+
+	r, err := ParseRange("1:00", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := Apply(streamInfo, r); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(streamInfo.Segments) != 2 {
+		t.Errorf("Expected every chunk to be kept, got %+v", streamInfo.Segments)
+	}
+	if streamInfo.ClipStart != time.Minute || streamInfo.ClipEnd != 0 {
+		t.Errorf("Unexpected clip range %v-%v", streamInfo.ClipStart, streamInfo.ClipEnd)
+	}
+
+	r, _ = ParseRange("2024-05-01T18:00:45Z", "")
+	if err := Apply(streamInfo, r); err == nil {
+		t.Error("Expected an error for a wall clock time")
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		start string
+		end   string
+		setup func(*models.StreamInfo)
+	}{
+		{name: "empty range", start: "30", end: "20"},
+		{name: "start past the end", start: "2m"},
+		{name: "live stream", start: "10", setup: func(s *models.StreamInfo) { s.IsLive = true }},
+		{
+			name:  "no program date time",
+			start: "2024-05-01T18:00:45Z",
+			setup: func(s *models.StreamInfo) {
+				for i := range s.Segments {
+					s.Segments[i].ProgramDateTime = time.Time{}
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		streamInfo := testStream()
+		if test.setup != nil {
+			test.setup(streamInfo)
+		}
+
+		r, err := ParseRange(test.start, test.end)
+		if err != nil {
+			t.Fatalf("%s: unexpected parse error: %v", test.name, err)
+		}
+		if err := Apply(streamInfo, r); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
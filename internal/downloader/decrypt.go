@@ -0,0 +1,131 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// keyFetch is a key request shared by the segments encrypted with the key.
+// done is closed once key and err are set.
+type keyFetch struct {
+	done chan struct{}
+	key  []byte
+	err  error
+}
+
+// fetchKey returns the key at uri, fetching it once however many segment
+// workers ask for it at the same time. The lock only guards the cache, so
+// workers needing keys that are already known aren't held up by the
+// request. A failed fetch is forgotten, so that retries request it again.
+func (d *Downloader) fetchKey(uri string, headers map[string]string) ([]byte, error) {
+	d.keysMu.Lock()
+	if fetch, ok := d.keys[uri]; ok {
+		d.keysMu.Unlock()
+		<-fetch.done
+		return fetch.key, fetch.err
+	}
+	fetch := &keyFetch{done: make(chan struct{})}
+	d.keys[uri] = fetch
+	d.keysMu.Unlock()
+
+	fetch.key, fetch.err = d.requestKey(uri, headers)
+	if fetch.err != nil {
+		d.keysMu.Lock()
+		delete(d.keys, uri)
+		d.keysMu.Unlock()
+	}
+	close(fetch.done)
+
+	return fetch.key, fetch.err
+}
+
+func (d *Downloader) requestKey(uri string, headers map[string]string) ([]byte, error) {
+	req, err := d.session.NewRequest(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error: %d %s", resp.StatusCode, resp.Status)
+	}
+
+	key, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid AES-128 key length: %d bytes", len(key))
+	}
+
+	return key, nil
+}
+
+// segmentIV returns the explicit IV of the key or, when absent, the media
+// sequence number as a big-endian 128-bit integer as required by RFC 8216.
+func segmentIV(key *models.Key, sequence int) ([]byte, error) {
+	if key.IV == "" {
+		iv := make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+		return iv, nil
+	}
+
+	ivHex := strings.TrimPrefix(strings.TrimPrefix(key.IV, "0x"), "0X")
+	iv, err := hex.DecodeString(ivHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IV %q: %w", key.IV, err)
+	}
+
+	if len(iv) > aes.BlockSize {
+		return nil, fmt.Errorf("invalid IV length: %d bytes", len(iv))
+	}
+
+	if len(iv) < aes.BlockSize {
+		iv = append(make([]byte, aes.BlockSize-len(iv)), iv...)
+	}
+
+	return iv, nil
+}
+
+func decryptAES128(data, key, iv []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("encrypted data is not a multiple of the block size")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, data)
+
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > aes.BlockSize || padding > len(decrypted) {
+		return nil, fmt.Errorf("invalid PKCS#7 padding")
+	}
+	if !bytes.Equal(decrypted[len(decrypted)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, fmt.Errorf("invalid PKCS#7 padding")
+	}
+
+	return decrypted[:len(decrypted)-padding], nil
+}
@@ -1,6 +1,7 @@
 package downloader
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,13 +10,18 @@ import (
 	"sync"
 	"time"
 
+	"github.com/yebrai/stream-snatchet/internal/session"
 	"github.com/yebrai/stream-snatchet/pkg/models"
 )
 
 type Downloader struct {
 	client   *http.Client
 	config   *models.Config
+	session  *session.Session
 	progress *models.DownloadProgress
+
+	keys   map[string]*keyFetch
+	keysMu sync.Mutex
 }
 
 type SegmentResult struct {
@@ -25,12 +31,18 @@ type SegmentResult struct {
 }
 
 func New(config *models.Config) *Downloader {
+	return NewWithSession(config, session.New(config))
+}
+
+// NewWithSession creates a downloader that sends the cookies and headers
+// collected by an extractor using the same session.
+func NewWithSession(config *models.Config, sess *session.Session) *Downloader {
 	return &Downloader{
-		client: &http.Client{
-			Timeout: time.Duration(config.TimeoutSeconds) * time.Second,
-		},
+		client:   sess.Client(),
 		config:   config,
+		session:  sess,
 		progress: &models.DownloadProgress{},
+		keys:     make(map[string]*keyFetch),
 	}
 }
 
@@ -39,18 +51,29 @@ func (d *Downloader) DownloadSegments(streamInfo *models.StreamInfo, outputDir s
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
+	var segments []models.Segment
+	segments = append(segments, streamInfo.InitSegments...)
+	segments = append(segments, streamInfo.Segments...)
+	if streamInfo.Audio != nil {
+		segments = append(segments, streamInfo.Audio.InitSegments...)
+		segments = append(segments, streamInfo.Audio.Segments...)
+	}
+	for _, subtitle := range streamInfo.Subtitles {
+		segments = append(segments, subtitle.Segments...)
+	}
+
 	d.progress = &models.DownloadProgress{
-		TotalSegments: len(streamInfo.Segments),
+		TotalSegments: len(segments),
 		Status:        "Initializing download...",
 	}
 
 	semaphore := make(chan struct{}, d.config.MaxConcurrency)
-	results := make(chan SegmentResult, len(streamInfo.Segments))
+	results := make(chan SegmentResult, len(segments))
 	var wg sync.WaitGroup
 
 	startTime := time.Now()
 
-	for _, segment := range streamInfo.Segments {
+	for _, segment := range segments {
 		wg.Add(1)
 		go func(seg models.Segment) {
 			defer wg.Done()
@@ -64,7 +87,7 @@ func (d *Downloader) DownloadSegments(streamInfo *models.StreamInfo, outputDir s
 			}
 
 			filePath := filepath.Join(outputDir, seg.Filename)
-			if err := d.downloadSegmentWithRetry(seg.URL, filePath, streamInfo.Headers); err != nil {
+			if err := d.downloadSegmentWithRetry(context.Background(), seg, filePath, streamInfo.Headers); err != nil {
 				result.Error = err
 			}
 
@@ -79,21 +102,19 @@ func (d *Downloader) DownloadSegments(streamInfo *models.StreamInfo, outputDir s
 
 	completed := 0
 	failed := 0
-	segmentFiles := make([]string, len(streamInfo.Segments))
 
 	for result := range results {
 		if result.Error != nil {
 			failed++
 			if d.config.Verbose {
-				fmt.Printf("Failed to download segment %d: %v\n", result.Index, result.Error)
+				fmt.Printf("Failed to download %s: %v\n", result.Filename, result.Error)
 			}
 		} else {
 			completed++
-			segmentFiles[result.Index] = result.Filename
 		}
 
 		elapsed := time.Since(startTime)
-		remaining := len(streamInfo.Segments) - completed - failed
+		remaining := len(segments) - completed - failed
 		var eta time.Duration
 		if completed > 0 {
 			avgTime := elapsed / time.Duration(completed)
@@ -102,8 +123,8 @@ func (d *Downloader) DownloadSegments(streamInfo *models.StreamInfo, outputDir s
 
 		speed := fmt.Sprintf("%.1f seg/s", float64(completed)/elapsed.Seconds())
 		status := fmt.Sprintf("Downloaded %d/%d segments (%.1f%%) - %s",
-			completed, len(streamInfo.Segments),
-			float64(completed)/float64(len(streamInfo.Segments))*100,
+			completed, len(segments),
+			float64(completed)/float64(len(segments))*100,
 			speed)
 
 		d.progress.Update(completed, completed+failed, status)
@@ -118,31 +139,30 @@ func (d *Downloader) DownloadSegments(streamInfo *models.StreamInfo, outputDir s
 	}
 
 	if failed > 0 {
-		return fmt.Errorf("failed to download %d out of %d segments", failed, len(streamInfo.Segments))
-	}
-
-	streamInfo.Segments = streamInfo.Segments[:0]
-	for i, filename := range segmentFiles {
-		if filename != "" {
-			streamInfo.Segments = append(streamInfo.Segments, models.Segment{
-				Index:    i,
-				Filename: filename,
-			})
-		}
+		return fmt.Errorf("failed to download %d out of %d segments", failed, len(segments))
 	}
 
 	return nil
 }
 
-func (d *Downloader) downloadSegmentWithRetry(url, filePath string, headers map[string]string) error {
+// downloadSegmentWithRetry downloads a segment, retrying failed attempts.
+// Cancelling ctx aborts the request in flight and any further attempts.
+func (d *Downloader) downloadSegmentWithRetry(ctx context.Context, segment models.Segment, filePath string, headers map[string]string) error {
 	var lastErr error
 
 	for attempt := 0; attempt < d.config.RetryAttempts; attempt++ {
 		if attempt > 0 {
-			time.Sleep(time.Duration(attempt) * time.Second)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
 		}
 
-		if err := d.downloadSegment(url, filePath, headers); err != nil {
+		if err := d.downloadSegment(ctx, segment, filePath, headers); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			lastErr = err
 			continue
 		}
@@ -152,38 +172,101 @@ func (d *Downloader) downloadSegmentWithRetry(url, filePath string, headers map[
 	return fmt.Errorf("failed after %d attempts: %w", d.config.RetryAttempts, lastErr)
 }
 
-func (d *Downloader) downloadSegment(url, filePath string, headers map[string]string) error {
-	req, err := http.NewRequest("GET", url, nil)
+func (d *Downloader) downloadSegment(ctx context.Context, segment models.Segment, filePath string, headers map[string]string) error {
+	req, err := d.session.NewRequest(segment.URL)
 	if err != nil {
 		return err
 	}
+	req = req.WithContext(ctx)
 
-	req.Header.Set("User-Agent", d.config.UserAgent)
-	req.Header.Set("Accept", "*/*")
 	req.Header.Set("Accept-Encoding", "gzip, deflate")
 
 	for key, value := range headers {
 		req.Header.Set(key, value)
 	}
 
+	if segment.ByteLength > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", segment.ByteOffset, segment.ByteOffset+segment.ByteLength-1))
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+
 	resp, err := d.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	var body io.Reader = resp.Body
+	if segment.ByteLength > 0 {
+		if err := checkPartialContent(resp, segment.ByteOffset); err != nil {
+			return err
+		}
+		body = io.LimitReader(resp.Body, segment.ByteLength)
+	} else if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("HTTP error: %d %s", resp.StatusCode, resp.Status)
 	}
 
+	if segment.Key != nil {
+		return d.writeDecrypted(body, segment, filePath, headers)
+	}
+
 	file, err := os.Create(filePath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	_, err = io.Copy(file, resp.Body)
-	return err
+	written, err := io.Copy(file, body)
+	if err != nil {
+		return err
+	}
+
+	if segment.ByteLength > 0 && written != segment.ByteLength {
+		return fmt.Errorf("short range response: got %d of %d bytes", written, segment.ByteLength)
+	}
+
+	return nil
+}
+
+func checkPartialContent(resp *http.Response, offset int64) error {
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("expected 206 Partial Content for range request, got %d %s", resp.StatusCode, resp.Status)
+	}
+
+	var start, end int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/", &start, &end); err != nil {
+		return fmt.Errorf("invalid Content-Range header: %q", resp.Header.Get("Content-Range"))
+	}
+
+	if start != offset {
+		return fmt.Errorf("range response starts at %d, expected %d", start, offset)
+	}
+
+	return nil
+}
+
+func (d *Downloader) writeDecrypted(body io.Reader, segment models.Segment, filePath string, headers map[string]string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	key, err := d.fetchKey(segment.Key.URI, headers)
+	if err != nil {
+		return fmt.Errorf("failed to fetch key: %w", err)
+	}
+
+	iv, err := segmentIV(segment.Key, segment.Sequence)
+	if err != nil {
+		return err
+	}
+
+	decrypted, err := decryptAES128(data, key, iv)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt segment %d: %w", segment.Index, err)
+	}
+
+	return os.WriteFile(filePath, decrypted, 0644)
 }
 
 func (d *Downloader) GetProgress() *models.DownloadProgress {
@@ -0,0 +1,436 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/internal/extractor"
+	"github.com/yebrai/stream-snatchet/internal/session"
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+func encryptAES128(t *testing.T, plaintext, key, iv []byte) []byte {
+	t.Helper()
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+	return encrypted
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("Failed to generate random bytes: %v", err)
+	}
+	return b
+}
+
+func TestNewDownloader(t *testing.T) {
+	config := models.DefaultConfig()
+	dl := New(config)
+
+	if dl == nil {
+		t.Fatal("Expected downloader to be created, got nil")
+	}
+
+	if dl.config != config {
+		t.Error("Expected config to be set correctly")
+	}
+
+	if dl.client == nil {
+		t.Error("Expected HTTP client to be initialized")
+	}
+}
+
+func TestSegmentIV(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         *models.Key
+		sequence    int
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "Implicit IV from media sequence",
+			key:      &models.Key{Method: "AES-128"},
+			sequence: 258,
+			expected: "00000000000000000000000000000102",
+		},
+		{
+			name:     "Explicit IV",
+			key:      &models.Key{Method: "AES-128", IV: "0x0123456789ABCDEF0123456789ABCDEF"},
+			sequence: 7,
+			expected: "0123456789abcdef0123456789abcdef",
+		},
+		{
+			name:     "Short explicit IV is left padded",
+			key:      &models.Key{Method: "AES-128", IV: "0x01"},
+			expected: "00000000000000000000000000000001",
+		},
+		{
+			name:        "Invalid IV",
+			key:         &models.Key{Method: "AES-128", IV: "0xZZ"},
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			iv, err := segmentIV(test.key, test.sequence)
+
+			if test.expectError {
+				if err == nil {
+					t.Error("Expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if hex.EncodeToString(iv) != test.expected {
+				t.Errorf("segmentIV() = %x, expected %s", iv, test.expected)
+			}
+		})
+	}
+}
+
+func TestDecryptAES128InvalidPadding(t *testing.T) {
+	key := randomBytes(t, aes.BlockSize)
+	iv := randomBytes(t, aes.BlockSize)
+
+	if _, err := decryptAES128(make([]byte, 15), key, iv); err == nil {
+		t.Error("Expected error for data that is not block aligned")
+	}
+
+	block, _ := aes.NewCipher(key)
+	encrypted := make([]byte, aes.BlockSize)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, bytes.Repeat([]byte{0x42}, aes.BlockSize))
+
+	if _, err := decryptAES128(encrypted, key, iv); err == nil {
+		t.Error("Expected error for invalid padding")
+	}
+}
+
+func TestFetchKeyOnce(t *testing.T) {
+	key := randomBytes(t, aes.BlockSize)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first request fails, so that the key has to be fetched again.
+		if atomic.AddInt32(&requests, 1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		w.Write(key)
+	}))
+	defer server.Close()
+
+	dl := New(models.DefaultConfig())
+	if _, err := dl.fetchKey(server.URL+"/key", nil); err == nil {
+		t.Fatal("Expected the failed key request to return an error")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := dl.fetchKey(server.URL+"/key", nil)
+			if err != nil || !bytes.Equal(got, key) {
+				t.Errorf("fetchKey() = %x, %v", got, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("Expected the key to be requested again once after the failure, got %d requests", n)
+	}
+}
+
+func TestDownloadEncryptedSegments(t *testing.T) {
+	key := randomBytes(t, aes.BlockSize)
+	explicitIV := randomBytes(t, aes.BlockSize)
+
+	plaintexts := [][]byte{
+		randomBytes(t, 1880),
+		randomBytes(t, 3760),
+		[]byte("plain segment"),
+	}
+
+	implicitIV := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(implicitIV[8:], 42)
+
+	payloads := map[string][]byte{
+		"/seg0.ts": encryptAES128(t, plaintexts[0], key, implicitIV),
+		"/seg1.ts": encryptAES128(t, plaintexts[1], key, explicitIV),
+		"/seg2.ts": plaintexts[2],
+	}
+
+	var keyRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/key.bin" {
+			if r.Header.Get("Referer") != "https://example.com/player" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			atomic.AddInt32(&keyRequests, 1)
+			w.Write(key)
+			return
+		}
+
+		payload, ok := payloads[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(payload)
+	}))
+	defer server.Close()
+
+	aesKey := &models.Key{Method: "AES-128", URI: server.URL + "/key.bin"}
+	streamInfo := &models.StreamInfo{
+		Headers: map[string]string{"Referer": "https://example.com/player"},
+		Segments: []models.Segment{
+			{URL: server.URL + "/seg0.ts", Index: 0, Sequence: 42, Filename: "segment_0000.ts", Key: aesKey},
+			{URL: server.URL + "/seg1.ts", Index: 1, Sequence: 43, Filename: "segment_0001.ts",
+				Key: &models.Key{Method: "AES-128", URI: aesKey.URI, IV: "0x" + hex.EncodeToString(explicitIV)}},
+			{URL: server.URL + "/seg2.ts", Index: 2, Sequence: 44, Filename: "segment_0002.ts"},
+		},
+	}
+
+	config := models.DefaultConfig()
+	config.RetryAttempts = 1
+	dl := New(config)
+
+	tempDir := t.TempDir()
+	if err := dl.DownloadSegments(streamInfo, tempDir); err != nil {
+		t.Fatalf("DownloadSegments failed: %v", err)
+	}
+
+	for i, plaintext := range plaintexts {
+		content, err := os.ReadFile(filepath.Join(tempDir, streamInfo.Segments[i].Filename))
+		if err != nil {
+			t.Fatalf("Failed to read segment %d: %v", i, err)
+		}
+
+		if !bytes.Equal(content, plaintext) {
+			t.Errorf("Segment %d content mismatch after decryption", i)
+		}
+	}
+
+	if keyRequests != 1 {
+		t.Errorf("Expected key to be fetched once, got %d requests", keyRequests)
+	}
+}
+
+func TestDownloadByteRangeSegments(t *testing.T) {
+	media := randomBytes(t, 4096)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/norange.ts" {
+			w.Write(media)
+			return
+		}
+		http.ServeContent(w, r, "media.ts", time.Time{}, bytes.NewReader(media))
+	}))
+	defer server.Close()
+
+	config := models.DefaultConfig()
+	config.RetryAttempts = 1
+	dl := New(config)
+
+	streamInfo := &models.StreamInfo{
+		Segments: []models.Segment{
+			{URL: server.URL + "/media.ts", Index: 0, Filename: "segment_0000.ts", ByteOffset: 0, ByteLength: 1000},
+			{URL: server.URL + "/media.ts", Index: 1, Filename: "segment_0001.ts", ByteOffset: 1000, ByteLength: 2000},
+			{URL: server.URL + "/media.ts", Index: 2, Filename: "segment_0002.ts", ByteOffset: 3000, ByteLength: 1096},
+		},
+	}
+
+	tempDir := t.TempDir()
+	if err := dl.DownloadSegments(streamInfo, tempDir); err != nil {
+		t.Fatalf("DownloadSegments failed: %v", err)
+	}
+
+	for _, segment := range streamInfo.Segments {
+		content, err := os.ReadFile(filepath.Join(tempDir, segment.Filename))
+		if err != nil {
+			t.Fatalf("Failed to read segment %d: %v", segment.Index, err)
+		}
+
+		expected := media[segment.ByteOffset : segment.ByteOffset+segment.ByteLength]
+		if !bytes.Equal(content, expected) {
+			t.Errorf("Segment %d content does not match requested byte range", segment.Index)
+		}
+	}
+
+	ignored := &models.StreamInfo{
+		Segments: []models.Segment{
+			{URL: server.URL + "/norange.ts", Index: 0, Filename: "segment_0000.ts", ByteOffset: 100, ByteLength: 100},
+		},
+	}
+	if err := dl.DownloadSegments(ignored, t.TempDir()); err == nil {
+		t.Error("Expected error when server ignores the Range header")
+	}
+}
+
+func TestDownloadSharesExtractorSession(t *testing.T) {
+	key := randomBytes(t, aes.BlockSize)
+	iv := make([]byte, aes.BlockSize)
+	plaintext := []byte("segment protected by cookie and referer")
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/embed" {
+			http.SetCookie(w, &http.Cookie{Name: "cdn_auth", Value: "token", Path: "/"})
+			w.Write([]byte(`<script>var src = "/hls/index.m3u8";</script>`))
+			return
+		}
+
+		cookie, err := r.Cookie("cdn_auth")
+		if err != nil || cookie.Value != "token" || r.Header.Get("Referer") != server.URL+"/embed" ||
+			r.Header.Get("Origin") != server.URL {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/hls/index.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXT-X-KEY:METHOD=AES-128,URI=\"/key.bin\",IV=0x00000000000000000000000000000000\n#EXTINF:10.0,\nsegment0.ts\n#EXT-X-ENDLIST\n"))
+		case "/key.bin":
+			w.Write(key)
+		case "/hls/segment0.ts":
+			w.Write(encryptAES128(t, plaintext, key, iv))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	config := models.DefaultConfig()
+	config.RetryAttempts = 1
+	sess := session.New(config)
+
+	streamInfo, err := extractor.NewWithSession(config, sess).ExtractFromIframe(server.URL + "/embed")
+	if err != nil {
+		t.Fatalf("Extraction failed: %v", err)
+	}
+
+	if streamInfo.Headers["Referer"] != server.URL+"/embed" || streamInfo.Headers["Origin"] != server.URL {
+		t.Errorf("Expected Referer and Origin in stream headers, got %v", streamInfo.Headers)
+	}
+
+	tempDir := t.TempDir()
+	if err := NewWithSession(config, sess).DownloadSegments(streamInfo, tempDir); err != nil {
+		t.Fatalf("DownloadSegments failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(tempDir, streamInfo.Segments[0].Filename))
+	if err != nil {
+		t.Fatalf("Failed to read segment: %v", err)
+	}
+	if !bytes.Equal(content, plaintext) {
+		t.Error("Decrypted segment does not match plaintext")
+	}
+}
+
+func TestDownloadThroughProxy(t *testing.T) {
+	key := randomBytes(t, 16)
+	iv := make([]byte, 16)
+	plaintext := []byte("proxied segment")
+
+	var mu sync.Mutex
+	var requested []string
+
+	// The proxy stand-in answers for stream.test, a host that can only be
+	// reached through it.
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := parseProxyAuthorization(r.Header.Get("Proxy-Authorization"))
+		if !ok || username != "user" || password != "secret" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.URL.Host != "stream.test" {
+			http.Error(w, "unexpected host "+r.URL.Host, http.StatusBadGateway)
+			return
+		}
+
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/embed":
+			w.Write([]byte(`<script>var src = "/hls/index.m3u8";</script>`))
+		case "/hls/index.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXT-X-KEY:METHOD=AES-128,URI=\"/key.bin\",IV=0x00000000000000000000000000000000\n#EXTINF:10.0,\nsegment0.ts\n#EXT-X-ENDLIST\n"))
+		case "/key.bin":
+			w.Write(key)
+		case "/hls/segment0.ts":
+			w.Write(encryptAES128(t, plaintext, key, iv))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer proxy.Close()
+
+	config := models.DefaultConfig()
+	config.RetryAttempts = 1
+	config.Proxy = strings.Replace(proxy.URL, "http://", "http://user:secret@", 1)
+	sess := session.New(config)
+
+	streamInfo, err := extractor.NewWithSession(config, sess).ExtractFromIframe("http://stream.test/embed")
+	if err != nil {
+		t.Fatalf("Extraction failed: %v", err)
+	}
+
+	tempDir := t.TempDir()
+	if err := NewWithSession(config, sess).DownloadSegments(streamInfo, tempDir); err != nil {
+		t.Fatalf("DownloadSegments failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(tempDir, streamInfo.Segments[0].Filename))
+	if err != nil {
+		t.Fatalf("Failed to read segment: %v", err)
+	}
+	if !bytes.Equal(content, plaintext) {
+		t.Error("Decrypted segment does not match plaintext")
+	}
+
+	sort.Strings(requested)
+	if got := strings.Join(requested, ","); got != "/embed,/hls/index.m3u8,/hls/segment0.ts,/key.bin" {
+		t.Errorf("Expected every request to go through the proxy, got %s", got)
+	}
+}
+
+func parseProxyAuthorization(header string) (string, string, bool) {
+	req := &http.Request{Header: http.Header{"Authorization": {header}}}
+	return req.BasicAuth()
+}
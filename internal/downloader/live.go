@@ -0,0 +1,292 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// RefreshFunc reloads a live media playlist and returns its current window.
+type RefreshFunc func() (*models.StreamInfo, error)
+
+// RecordLive records a live stream by downloading every segment of the
+// current playlist window and then reloading the playlist once per target
+// duration, appending only segments whose media sequence number has not been
+// seen yet. Recording stops when the playlist ends, when maxDuration of media
+// has been recorded (if non-zero) or when ctx is cancelled. A separate audio
+// rendition is recorded alongside the video the same way. streamInfo is
+// updated to describe exactly the recorded segments so it can be merged like
+// a finished stream.
+func (d *Downloader) RecordLive(ctx context.Context, streamInfo *models.StreamInfo, outputDir string, refresh RefreshFunc, maxDuration time.Duration) error {
+	r := &liveRecorder{
+		ctx:         ctx,
+		d:           d,
+		outputDir:   outputDir,
+		headers:     streamInfo.Headers,
+		maxDuration: maxDuration,
+		startTime:   time.Now(),
+		video:       newLiveTrack(""),
+	}
+	if streamInfo.Audio != nil {
+		r.audio = newLiveTrack("audio_")
+	}
+
+	d.progress = &models.DownloadProgress{Status: "Recording live stream..."}
+
+	playlist := streamInfo
+	failedRefreshes := 0
+	for {
+		newSegments := r.record(playlist)
+
+		if r.video.done(maxDuration) || !playlist.IsLive || ctx.Err() != nil {
+			break
+		}
+
+		// Reload after a full target duration when new segments arrived,
+		// otherwise after half of it as recommended by RFC 8216.
+		interval := playlist.TargetDuration
+		if interval <= 0 {
+			interval = 10 * time.Second
+		}
+		if newSegments == 0 {
+			interval /= 2
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		refreshed, err := refresh()
+		if err != nil {
+			failedRefreshes++
+			if d.config.Verbose {
+				fmt.Printf("\nFailed to reload live playlist: %v\n", err)
+			}
+			if failedRefreshes >= d.config.RetryAttempts {
+				break
+			}
+			continue
+		}
+		failedRefreshes = 0
+		playlist = refreshed
+	}
+
+	if d.config.Verbose {
+		fmt.Println()
+	}
+
+	if len(r.video.segments) == 0 {
+		return fmt.Errorf("no live segments were recorded")
+	}
+
+	streamInfo.Segments = r.video.segments
+	streamInfo.InitSegments = r.video.initSegments
+	streamInfo.Duration = time.Duration(r.video.recorded * float64(time.Second))
+	streamInfo.IsLive = false
+
+	// Subtitle playlists are not polled, so none of their segments were
+	// recorded.
+	for i := range streamInfo.Subtitles {
+		streamInfo.Subtitles[i].Segments = nil
+	}
+
+	if r.audio != nil {
+		if len(r.audio.segments) == 0 {
+			return fmt.Errorf("no live audio segments were recorded")
+		}
+		audio := *streamInfo.Audio
+		audio.Segments = r.audio.segments
+		audio.InitSegments = r.audio.initSegments
+		streamInfo.Audio = &audio
+	}
+
+	return nil
+}
+
+type liveRecorder struct {
+	// ctx is the recording's context; cancelling it aborts the segment
+	// downloads in flight.
+	ctx         context.Context
+	d           *Downloader
+	outputDir   string
+	headers     map[string]string
+	maxDuration time.Duration
+	startTime   time.Time
+
+	video *liveTrack
+	audio *liveTrack
+}
+
+// liveTrack is the recording state of one media playlist.
+type liveTrack struct {
+	prefix       string
+	lastSeq      int
+	nextFile     int
+	recorded     float64
+	failed       int
+	segments     []models.Segment
+	initSegments []models.Segment
+	// inits maps the URL and byte range of each init segment to the file it
+	// was downloaded to, so reloads don't fetch the same one twice.
+	inits map[string]string
+}
+
+func newLiveTrack(prefix string) *liveTrack {
+	return &liveTrack{
+		prefix:  prefix,
+		lastSeq: -1,
+		inits:   make(map[string]string),
+	}
+}
+
+func (t *liveTrack) done(maxDuration time.Duration) bool {
+	return maxDuration > 0 && t.recorded >= maxDuration.Seconds()
+}
+
+// record downloads the new segments of the video playlist and, when an
+// audio rendition is recorded alongside, of its audio playlist. It returns
+// how many new video segments there were.
+func (r *liveRecorder) record(playlist *models.StreamInfo) int {
+	newSegments := r.recordTrack(r.video, playlist.Segments, playlist.InitSegments)
+	if r.audio != nil && playlist.Audio != nil {
+		r.recordTrack(r.audio, playlist.Audio.Segments, playlist.Audio.InitSegments)
+	}
+
+	status := fmt.Sprintf("Recording live: %d segments (%v)", len(r.video.segments),
+		(time.Duration(r.video.recorded) * time.Second).Round(time.Second))
+	if failed := r.video.failed + r.audioFailed(); failed > 0 {
+		status += fmt.Sprintf(", %d failed", failed)
+	}
+	r.d.progress.Update(len(r.video.segments), len(r.video.segments), status)
+	if r.d.config.Verbose {
+		fmt.Printf("\r%s - elapsed %v", status, time.Since(r.startTime).Round(time.Second))
+	}
+
+	return newSegments
+}
+
+func (r *liveRecorder) audioFailed() int {
+	if r.audio == nil {
+		return 0
+	}
+	return r.audio.failed
+}
+
+// recordTrack downloads the segments that follow the last recorded media
+// sequence number of the track and returns how many were new.
+func (r *liveRecorder) recordTrack(t *liveTrack, segments, initSegments []models.Segment) int {
+	initNames := make(map[string]string)
+	for _, init := range initSegments {
+		name, err := r.recordInit(t, init)
+		if err != nil {
+			if r.d.config.Verbose {
+				fmt.Printf("\nFailed to download init segment %s: %v\n", init.URL, err)
+			}
+			continue
+		}
+		initNames[init.Filename] = name
+	}
+
+	var pending []models.Segment
+	var pendingDuration float64
+	for _, segment := range segments {
+		if segment.Sequence <= t.lastSeq {
+			continue
+		}
+		if r.maxDuration > 0 && t.recorded+pendingDuration >= r.maxDuration.Seconds() {
+			break
+		}
+		if t.lastSeq >= 0 && len(pending) == 0 && segment.Sequence > t.lastSeq+1 && r.d.config.Verbose {
+			fmt.Printf("\nMissed %d live segments\n", segment.Sequence-t.lastSeq-1)
+		}
+
+		if segment.InitFilename != "" {
+			name, ok := initNames[segment.InitFilename]
+			if !ok {
+				// Unplayable without its init segment.
+				t.lastSeq = segment.Sequence
+				t.failed++
+				continue
+			}
+			segment.InitFilename = name
+		}
+
+		segment.Filename = fmt.Sprintf("%ssegment_%04d%s", t.prefix, t.nextFile, filepath.Ext(segment.Filename))
+		t.nextFile++
+
+		pending = append(pending, segment)
+		pendingDuration += segment.Duration
+	}
+	if len(pending) == 0 {
+		return 0
+	}
+
+	errs := r.download(pending)
+	for i, segment := range pending {
+		t.lastSeq = segment.Sequence
+		if errs[i] != nil {
+			if r.ctx.Err() != nil {
+				// Cut short by stopping the recording.
+				continue
+			}
+			t.failed++
+			if r.d.config.Verbose {
+				fmt.Printf("\nFailed to download %s: %v\n", segment.Filename, errs[i])
+			}
+			continue
+		}
+
+		segment.Index = len(t.segments)
+		t.segments = append(t.segments, segment)
+		t.recorded += segment.Duration
+	}
+
+	return len(pending)
+}
+
+func (r *liveRecorder) recordInit(t *liveTrack, init models.Segment) (string, error) {
+	key := fmt.Sprintf("%s@%d-%d", init.URL, init.ByteOffset, init.ByteLength)
+	if name, ok := t.inits[key]; ok {
+		return name, nil
+	}
+
+	init.Index = len(t.initSegments)
+	init.Filename = fmt.Sprintf("%sinit_%02d%s", t.prefix, init.Index, filepath.Ext(init.Filename))
+	if err := r.d.downloadSegmentWithRetry(r.ctx, init, filepath.Join(r.outputDir, init.Filename), r.headers); err != nil {
+		return "", err
+	}
+
+	t.inits[key] = init.Filename
+	t.initSegments = append(t.initSegments, init)
+	return init.Filename, nil
+}
+
+// download fetches segments concurrently and returns the error of each.
+func (r *liveRecorder) download(segments []models.Segment) []error {
+	errs := make([]error, len(segments))
+	semaphore := make(chan struct{}, r.d.config.MaxConcurrency)
+	var wg sync.WaitGroup
+
+	for i, segment := range segments {
+		wg.Add(1)
+		go func(i int, seg models.Segment) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			errs[i] = r.d.downloadSegmentWithRetry(r.ctx, seg, filepath.Join(r.outputDir, seg.Filename), r.headers)
+		}(i, segment)
+	}
+
+	wg.Wait()
+	return errs
+}
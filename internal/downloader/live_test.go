@@ -0,0 +1,255 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// livePlaylist returns a sliding window of three segments starting at
+// sequence, ending the stream once endAt is reached.
+func livePlaylist(serverURL string, sequence, endAt int) *models.StreamInfo {
+	streamInfo := &models.StreamInfo{
+		IsLive:         sequence < endAt,
+		TargetDuration: 20 * time.Millisecond,
+	}
+	for i := 0; i < 3; i++ {
+		seq := sequence + i
+		streamInfo.Segments = append(streamInfo.Segments, models.Segment{
+			URL:      fmt.Sprintf("%s/seg%d.ts", serverURL, seq),
+			Index:    i,
+			Sequence: seq,
+			Duration: 2,
+			Filename: fmt.Sprintf("segment_%04d.ts", i),
+		})
+	}
+	return streamInfo
+}
+
+func liveServer(t *testing.T) (*httptest.Server, func() map[string]int) {
+	t.Helper()
+
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		w.Write([]byte(r.URL.Path))
+	}))
+
+	return server, func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func TestRecordLiveUntilEndList(t *testing.T) {
+	server, requests := liveServer(t)
+	defer server.Close()
+
+	sequence := 100
+	refresh := func() (*models.StreamInfo, error) {
+		sequence += 2
+		return livePlaylist(server.URL, sequence, 106), nil
+	}
+
+	config := models.DefaultConfig()
+	config.RetryAttempts = 1
+	dl := New(config)
+
+	streamInfo := livePlaylist(server.URL, sequence, 106)
+	tempDir := t.TempDir()
+	if err := dl.RecordLive(context.Background(), streamInfo, tempDir, refresh, 0); err != nil {
+		t.Fatalf("RecordLive failed: %v", err)
+	}
+
+	// Windows 100-102, 102-104, 104-106 and the final 106-108.
+	if len(streamInfo.Segments) != 9 {
+		t.Fatalf("Expected 9 segments, got %d", len(streamInfo.Segments))
+	}
+	if streamInfo.IsLive {
+		t.Error("Expected recorded stream to no longer be live")
+	}
+	if streamInfo.Duration != 18*time.Second {
+		t.Errorf("Expected 18s recorded, got %v", streamInfo.Duration)
+	}
+
+	for i, segment := range streamInfo.Segments {
+		if segment.Sequence != 100+i || segment.Index != i {
+			t.Errorf("Segment %d has sequence %d and index %d", i, segment.Sequence, segment.Index)
+		}
+
+		content, err := os.ReadFile(filepath.Join(tempDir, segment.Filename))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", segment.Filename, err)
+		}
+		if string(content) != fmt.Sprintf("/seg%d.ts", segment.Sequence) {
+			t.Errorf("%s contains %q", segment.Filename, content)
+		}
+	}
+
+	for path, count := range requests() {
+		if count != 1 {
+			t.Errorf("%s requested %d times, expected once", path, count)
+		}
+	}
+}
+
+func TestRecordLiveStopsAtDuration(t *testing.T) {
+	server, _ := liveServer(t)
+	defer server.Close()
+
+	sequence := 0
+	refresh := func() (*models.StreamInfo, error) {
+		sequence++
+		return livePlaylist(server.URL, sequence, 1000), nil
+	}
+
+	config := models.DefaultConfig()
+	config.RetryAttempts = 1
+	dl := New(config)
+
+	streamInfo := livePlaylist(server.URL, sequence, 1000)
+	if err := dl.RecordLive(context.Background(), streamInfo, t.TempDir(), refresh, 9*time.Second); err != nil {
+		t.Fatalf("RecordLive failed: %v", err)
+	}
+
+	if len(streamInfo.Segments) != 5 {
+		t.Errorf("Expected 5 segments of 2s to cover 9s, got %d", len(streamInfo.Segments))
+	}
+}
+
+func TestRecordLiveStopsOnCancel(t *testing.T) {
+	server, _ := liveServer(t)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	refreshes := 0
+	refresh := func() (*models.StreamInfo, error) {
+		refreshes++
+		if refreshes == 2 {
+			cancel()
+		}
+		return livePlaylist(server.URL, refreshes*3, 1000), nil
+	}
+
+	config := models.DefaultConfig()
+	config.RetryAttempts = 1
+	dl := New(config)
+
+	streamInfo := livePlaylist(server.URL, 0, 1000)
+	done := make(chan error, 1)
+	go func() {
+		done <- dl.RecordLive(ctx, streamInfo, t.TempDir(), refresh, 0)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("RecordLive failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RecordLive did not stop after cancellation")
+	}
+
+	if len(streamInfo.Segments) == 0 || len(streamInfo.Segments) > 9 {
+		t.Errorf("Unexpected number of recorded segments: %d", len(streamInfo.Segments))
+	}
+}
+
+func TestRecordLiveCancelAbortsSegmentDownloads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path < "/seg3.ts" {
+			w.Write([]byte(r.URL.Path))
+			return
+		}
+		// Later segments stall until the recording is stopped.
+		once.Do(cancel)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	refresh := func() (*models.StreamInfo, error) {
+		return livePlaylist(server.URL, 3, 1000), nil
+	}
+
+	config := models.DefaultConfig()
+	config.RetryAttempts = 3
+	dl := New(config)
+
+	streamInfo := livePlaylist(server.URL, 0, 1000)
+	start := time.Now()
+	if err := dl.RecordLive(ctx, streamInfo, t.TempDir(), refresh, 0); err != nil {
+		t.Fatalf("RecordLive failed: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected stalled segment downloads to be aborted, took %v", elapsed)
+	}
+	if len(streamInfo.Segments) != 3 {
+		t.Errorf("Expected only the segments completed before stopping, got %d", len(streamInfo.Segments))
+	}
+}
+
+func TestRecordLiveWithAudioRendition(t *testing.T) {
+	server, _ := liveServer(t)
+	defer server.Close()
+
+	withAudio := func(streamInfo *models.StreamInfo) *models.StreamInfo {
+		audio := &models.Track{Type: "audio", Language: "en"}
+		for _, segment := range streamInfo.Segments {
+			segment.URL = strings.Replace(segment.URL, "/seg", "/audio", 1)
+			segment.Filename = "audio_" + strings.Replace(segment.Filename, ".ts", ".aac", 1)
+			audio.Segments = append(audio.Segments, segment)
+		}
+		streamInfo.Audio = audio
+		return streamInfo
+	}
+
+	sequence := 0
+	refresh := func() (*models.StreamInfo, error) {
+		sequence += 3
+		return withAudio(livePlaylist(server.URL, sequence, 3)), nil
+	}
+
+	config := models.DefaultConfig()
+	config.RetryAttempts = 1
+	dl := New(config)
+
+	streamInfo := withAudio(livePlaylist(server.URL, 0, 3))
+	tempDir := t.TempDir()
+	if err := dl.RecordLive(context.Background(), streamInfo, tempDir, refresh, 0); err != nil {
+		t.Fatalf("RecordLive failed: %v", err)
+	}
+
+	if len(streamInfo.Segments) != 6 || streamInfo.Audio == nil || len(streamInfo.Audio.Segments) != 6 {
+		t.Fatalf("Expected 6 video and 6 audio segments, got %d and %+v", len(streamInfo.Segments), streamInfo.Audio)
+	}
+
+	last := streamInfo.Audio.Segments[5]
+	if last.Filename != "audio_segment_0005.aac" {
+		t.Errorf("Unexpected audio filename %s", last.Filename)
+	}
+	content, err := os.ReadFile(filepath.Join(tempDir, last.Filename))
+	if err != nil || string(content) != "/audio5.ts" {
+		t.Errorf("Unexpected audio content %q: %v", content, err)
+	}
+}
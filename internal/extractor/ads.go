@@ -0,0 +1,85 @@
+package extractor
+
+import (
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// stripAds removes server-side inserted ad breaks from a media playlist:
+// segments the playlist marks as ads with CUE-OUT/CUE-IN or SCTE-35
+// DATERANGE tags, and discontinuity runs served from a different host and
+// directory than the bulk of the stream. Init segments no longer referenced
+// by any remaining segment are dropped too. The removed breaks are returned
+// with their position on the timeline of the unstripped playlist.
+func stripAds(segments, initSegments []models.Segment) ([]models.Segment, []models.Segment, []models.AdBreak) {
+	runs := models.DiscontinuityRuns(segments)
+	foreign := make(map[int]bool)
+
+	if len(runs) > 1 {
+		// The origin serving most of the non-ad media is the content's.
+		durations := make(map[string]float64)
+		for _, run := range runs {
+			for _, segment := range run {
+				if !segment.Ad {
+					durations[segmentOrigin(segment.URL)] += segment.Duration
+				}
+			}
+		}
+
+		mainOrigin := ""
+		for origin, duration := range durations {
+			if mainOrigin == "" || duration > durations[mainOrigin] ||
+				(duration == durations[mainOrigin] && origin < mainOrigin) {
+				mainOrigin = origin
+			}
+		}
+
+		for i, run := range runs {
+			if segmentOrigin(run[0].URL) != mainOrigin {
+				foreign[i] = true
+			}
+		}
+	}
+
+	var kept []models.Segment
+	var breaks []models.AdBreak
+	usedInits := make(map[string]bool)
+	var offset time.Duration
+	for i, run := range runs {
+		for _, segment := range run {
+			duration := time.Duration(segment.Duration * float64(time.Second))
+			if foreign[i] || segment.Ad {
+				if n := len(breaks); n > 0 && breaks[n-1].Start+breaks[n-1].Duration == offset {
+					breaks[n-1].Duration += duration
+				} else {
+					breaks = append(breaks, models.AdBreak{Start: offset, Duration: duration})
+				}
+			} else {
+				kept = append(kept, segment)
+				usedInits[segment.InitFilename] = true
+			}
+			offset += duration
+		}
+	}
+
+	var keptInits []models.Segment
+	for _, init := range initSegments {
+		if usedInits[init.Filename] {
+			keptInits = append(keptInits, init)
+		}
+	}
+
+	return kept, keptInits, breaks
+}
+
+// segmentOrigin returns the host and directory a segment is served from.
+func segmentOrigin(segmentURL string) string {
+	u, err := url.Parse(segmentURL)
+	if err != nil {
+		return segmentURL
+	}
+	return u.Host + path.Dir(u.Path)
+}
@@ -0,0 +1,136 @@
+package extractor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+const adBreakManifest = `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-DISCONTINUITY-SEQUENCE:4
+#EXTINF:6.0,
+content/seg0.ts
+#EXTINF:6.0,
+content/seg1.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:5.0,
+https://ads.example.net/creative/a0.ts
+#EXTINF:5.0,
+https://ads.example.net/creative/a1.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:6.0,
+content/seg2.ts
+#EXT-X-CUE-OUT:12
+#EXTINF:6.0,
+content/slate0.ts
+#EXT-X-CUE-OUT-CONT:6/12
+#EXTINF:6.0,
+content/slate1.ts
+#EXT-X-CUE-IN
+#EXTINF:6.0,
+content/seg3.ts
+#EXT-X-DATERANGE:ID="break-1",START-DATE="2024-01-01T00:00:30Z",PLANNED-DURATION=11.989,SCTE35-OUT=0xFC30
+#EXTINF:6.0,
+content/scte0.ts
+#EXTINF:6.0,
+content/scte1.ts
+#EXTINF:6.0,
+content/seg4.ts
+#EXT-X-DATERANGE:ID="break-2",START-DATE="2024-01-01T00:00:48Z",SCTE35-OUT=0xFC30
+#EXTINF:6.0,
+content/scte2.ts
+#EXT-X-DATERANGE:ID="break-2",START-DATE="2024-01-01T00:00:48Z",SCTE35-IN=0xFC30
+#EXTINF:6.0,
+content/seg5.ts
+#EXT-X-ENDLIST
+`
+
+func TestParseManifestDiscontinuities(t *testing.T) {
+	ext := New(models.DefaultConfig())
+	streamInfo := &models.StreamInfo{BaseURL: "https://cdn.example.com/vod/"}
+	if err := ext.parseManifest(adBreakManifest, streamInfo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(streamInfo.Segments) != 13 {
+		t.Fatalf("Expected all 13 segments without --skip-ads, got %d", len(streamInfo.Segments))
+	}
+
+	expected := []struct {
+		discontinuity int
+		ad            bool
+	}{
+		{4, false}, {4, false},
+		{5, false}, {5, false},
+		{6, false}, {6, true}, {6, true}, {6, false},
+		{6, true}, {6, true}, {6, false},
+		{6, true}, {6, false},
+	}
+	for i, segment := range streamInfo.Segments {
+		if segment.Discontinuity != expected[i].discontinuity || segment.Ad != expected[i].ad {
+			t.Errorf("Segment %d (%s): discontinuity %d ad %v, expected %d %v", i, segment.URL,
+				segment.Discontinuity, segment.Ad, expected[i].discontinuity, expected[i].ad)
+		}
+	}
+}
+
+func TestParseManifestSkipAds(t *testing.T) {
+	config := models.DefaultConfig()
+	config.SkipAds = true
+
+	ext := New(config)
+	streamInfo := &models.StreamInfo{BaseURL: "https://cdn.example.com/vod/"}
+	if err := ext.parseManifest(adBreakManifest, streamInfo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var names []string
+	for _, segment := range streamInfo.Segments {
+		names = append(names, segment.URL[strings.LastIndex(segment.URL, "/")+1:])
+	}
+	if got := strings.Join(names, ","); got != "seg0.ts,seg1.ts,seg2.ts,seg3.ts,seg4.ts,seg5.ts" {
+		t.Errorf("Expected only content segments, got %s", got)
+	}
+	if streamInfo.Duration.Seconds() != 36 {
+		t.Errorf("Expected 36s of content, got %v", streamInfo.Duration)
+	}
+
+	expected := []models.AdBreak{
+		{Start: 12 * time.Second, Duration: 10 * time.Second},
+		{Start: 28 * time.Second, Duration: 12 * time.Second},
+		{Start: 46 * time.Second, Duration: 12 * time.Second},
+		{Start: 64 * time.Second, Duration: 6 * time.Second},
+	}
+	if len(streamInfo.AdBreaks) != len(expected) {
+		t.Fatalf("Expected %d ad breaks, got %+v", len(expected), streamInfo.AdBreaks)
+	}
+	for i, adBreak := range streamInfo.AdBreaks {
+		if adBreak != expected[i] {
+			t.Errorf("Ad break %d: expected %+v, got %+v", i, expected[i], adBreak)
+		}
+	}
+}
+
+func TestStripAdsDropsUnusedInitSegments(t *testing.T) {
+	segments := []models.Segment{
+		{URL: "https://cdn.example.com/v/1.m4s", Duration: 6, InitFilename: "init_00.mp4"},
+		{URL: "https://cdn.example.com/v/2.m4s", Duration: 6, InitFilename: "init_00.mp4"},
+		{URL: "https://ads.example.net/x/1.m4s", Duration: 6, InitFilename: "init_01.mp4", Discontinuity: 1},
+		{URL: "https://cdn.example.com/v/3.m4s", Duration: 6, InitFilename: "init_00.mp4", Discontinuity: 2},
+	}
+	inits := []models.Segment{{Filename: "init_00.mp4"}, {Filename: "init_01.mp4"}}
+
+	kept, keptInits, breaks := stripAds(segments, inits)
+	if len(kept) != 3 || kept[2].URL != "https://cdn.example.com/v/3.m4s" {
+		t.Errorf("Expected the ad run to be dropped, got %+v", kept)
+	}
+	if len(keptInits) != 1 || keptInits[0].Filename != "init_00.mp4" {
+		t.Errorf("Expected only the content init segment, got %+v", keptInits)
+	}
+	if len(breaks) != 1 || breaks[0] != (models.AdBreak{Start: 12 * time.Second, Duration: 6 * time.Second}) {
+		t.Errorf("Expected the ad run as a break at 12s, got %+v", breaks)
+	}
+}
@@ -0,0 +1,190 @@
+package extractor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// chooseCandidate picks the manifest to download among those a page offers
+// and returns the candidates with what probing them revealed. With a
+// configured source index, that candidate is used as is. Otherwise the
+// longest one wins, so that a trailer or preview doesn't beat the main
+// feature, with ties going to the highest quality and then to the order
+// discovery preferred.
+func (e *Extractor) chooseCandidate(candidates []models.Candidate) ([]models.Candidate, int, error) {
+	probed := make([]models.Candidate, len(candidates))
+	copy(probed, candidates)
+	for i := range probed {
+		e.probeCandidate(&probed[i])
+	}
+
+	if e.config.Verbose {
+		fmt.Printf("Found %d manifest candidates:\n", len(probed))
+		for i, candidate := range probed {
+			fmt.Printf("  %d. %s\n", i+1, DescribeCandidate(candidate))
+		}
+	}
+
+	if index := e.config.SourceIndex; index > 0 {
+		if index > len(probed) {
+			return nil, 0, fmt.Errorf("source %d not found, the page offers %d", index, len(probed))
+		}
+		return probed, index - 1, nil
+	}
+
+	return probed, bestCandidate(probed), nil
+}
+
+// LoadCandidate loads the candidate at index of an extraction's Candidates
+// in place of the one it chose, without crawling the page again, so that it
+// works for inputs that can't be read twice such as standard input.
+// Subtitles found on the page are kept; those of the replaced manifest are
+// not.
+func (e *Extractor) LoadCandidate(extracted *models.StreamInfo, index int) (*models.StreamInfo, error) {
+	if index < 0 || index >= len(extracted.Candidates) {
+		return nil, fmt.Errorf("source %d not found, the page offers %d", index+1, len(extracted.Candidates))
+	}
+	candidate := extracted.Candidates[index]
+
+	streamInfo := &models.StreamInfo{
+		IframeURL:   extracted.IframeURL,
+		ManifestURL: candidate.URL,
+		Chain:       extracted.Chain,
+		Title:       extracted.Title,
+		Candidates:  extracted.Candidates,
+	}
+	for _, track := range extracted.Subtitles {
+		if track.GroupID == "" {
+			track.Segments = nil
+			streamInfo.Subtitles = append(streamInfo.Subtitles, track)
+		}
+	}
+
+	var err error
+	if candidate.Format == kindProgressive {
+		err = e.loadProgressive(streamInfo, candidate.URL)
+	} else {
+		err = e.loadManifest(streamInfo)
+	}
+	if err != nil {
+		return nil, err
+	}
+	streamInfo.Headers = e.session.Headers()
+
+	e.finishExtraction(streamInfo)
+	return streamInfo, nil
+}
+
+// bestCandidate returns the index of the longest candidate that could be
+// probed, preferring higher quality between equally long ones. The first
+// candidate is returned when none could be probed, so that loading it
+// reports the problem.
+func bestCandidate(candidates []models.Candidate) int {
+	best := -1
+	for i, candidate := range candidates {
+		if candidate.Error != "" {
+			continue
+		}
+		if best < 0 || betterCandidate(candidate, candidates[best]) {
+			best = i
+		}
+	}
+
+	if best < 0 {
+		return 0
+	}
+	return best
+}
+
+func betterCandidate(a, b models.Candidate) bool {
+	if a.Duration != b.Duration {
+		return a.Duration > b.Duration
+	}
+	if a.Height != b.Height {
+		return a.Height > b.Height
+	}
+	return a.Bandwidth > b.Bandwidth
+}
+
+// probeCandidate fetches a candidate's manifest, and for a master playlist
+// its best variant, to fill in its format, duration and quality. Failures
+// are recorded on the candidate so that one broken candidate doesn't hide
+// the others.
+func (e *Extractor) probeCandidate(candidate *models.Candidate) {
+	content, finalURL, kind, err := e.fetchPage(candidate.URL, candidate.Page)
+	if err != nil {
+		candidate.Error = err.Error()
+		return
+	}
+
+	probe := &models.StreamInfo{BaseURL: e.getBaseURL(finalURL)}
+	switch kind {
+	case kindHLS:
+		candidate.Format = "hls"
+		err = e.probeHLS(content, finalURL, probe)
+	case kindDASH:
+		candidate.Format = "dash"
+		err = e.parseDASHManifest(content, finalURL, probe)
+	case kindProgressive:
+		candidate.Format = "progressive"
+		return
+	default:
+		err = fmt.Errorf("not an HLS or DASH manifest")
+	}
+	if err != nil {
+		candidate.Error = err.Error()
+		return
+	}
+
+	candidate.Duration = probe.Duration
+	candidate.Quality = probe.Quality
+	for _, variant := range probe.Variants {
+		if variant.Height > candidate.Height {
+			candidate.Height = variant.Height
+		}
+		if variant.Bandwidth > candidate.Bandwidth {
+			candidate.Bandwidth = variant.Bandwidth
+		}
+	}
+}
+
+func (e *Extractor) probeHLS(content, playlistURL string, probe *models.StreamInfo) error {
+	if isMasterPlaylist(content) {
+		variants, err := e.parseMasterPlaylist(content, playlistURL)
+		if err != nil {
+			return err
+		}
+		variant, err := selectVariant(variants, "best")
+		if err != nil {
+			return err
+		}
+		probe.Variants = variants
+		probe.Quality = variant.Label()
+
+		content, playlistURL, err = e.fetchContent(variant.URL, playlistURL)
+		if err != nil {
+			return err
+		}
+		probe.BaseURL = e.getBaseURL(playlistURL)
+	}
+
+	return e.parseManifest(content, probe)
+}
+
+// DescribeCandidate formats a candidate on one line for listings.
+func DescribeCandidate(candidate models.Candidate) string {
+	if candidate.Error != "" {
+		return fmt.Sprintf("%s [%s] error: %s", candidate.URL, candidate.Source, candidate.Error)
+	}
+
+	details := candidate.Format
+	if candidate.Duration > 0 {
+		details += fmt.Sprintf(", %v", candidate.Duration.Round(time.Second))
+	}
+	if candidate.Quality != "" {
+		details += ", " + candidate.Quality
+	}
+	return fmt.Sprintf("%s [%s] %s", candidate.URL, candidate.Source, details)
+}
@@ -0,0 +1,141 @@
+package extractor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+func TestDiscoverManifestCandidates(t *testing.T) {
+	content := `<video><source src="/html5/index.m3u8" type="application/x-mpegURL"></video>
+<script>
+jwplayer("player").setup({sources: [{file: "https://cdn.example.com/main/master.m3u8"}]});
+var preview = "https://cdn.example.com/preview/index.m3u8";
+</script>`
+
+	discovery, err := discoverManifest(content, "https://example.com/embed/1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []models.Candidate{
+		{URL: "https://cdn.example.com/main/master.m3u8", Source: "jwplayer config"},
+		{URL: "https://example.com/html5/index.m3u8", Source: "<source> tag"},
+		{URL: "https://cdn.example.com/preview/index.m3u8", Source: "pattern"},
+	}
+	if len(discovery.candidates) != len(expected) {
+		t.Fatalf("Expected %d candidates, got %+v", len(expected), discovery.candidates)
+	}
+	for i, candidate := range discovery.candidates {
+		if candidate.URL != expected[i].URL || candidate.Source != expected[i].Source || candidate.Page != "https://example.com/embed/1" {
+			t.Errorf("Candidate %d: got %+v, expected %+v", i, candidate, expected[i])
+		}
+	}
+	if discovery.manifestURL != expected[0].URL {
+		t.Errorf("Expected the player config source to stay the default, got %s", discovery.manifestURL)
+	}
+}
+
+func TestExtractFromIframePicksLongestCandidate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/embed":
+			w.Write([]byte(`<script>
+var trailer = "/trailer/index.m3u8";
+var broken = "/missing/index.m3u8";
+var movie = "/movie/master.m3u8";
+</script>`))
+		case "/trailer/index.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXTINF:10.0,\nt0.ts\n#EXT-X-ENDLIST\n"))
+		case "/movie/master.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360\nlow.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=3000000,RESOLUTION=1920x1080\nhigh.m3u8\n"))
+		case "/movie/low.m3u8", "/movie/high.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXTINF:600.0,\nm0.ts\n#EXTINF:600.0,\nm1.ts\n#EXT-X-ENDLIST\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	streamInfo, err := New(models.DefaultConfig()).ExtractFromIframe(server.URL + "/embed")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if streamInfo.ManifestURL != server.URL+"/movie/master.m3u8" {
+		t.Errorf("Expected the longest candidate, got %s", streamInfo.ManifestURL)
+	}
+	if len(streamInfo.Candidates) != 3 {
+		t.Fatalf("Expected 3 candidates, got %+v", streamInfo.Candidates)
+	}
+
+	trailer, broken, movie := streamInfo.Candidates[0], streamInfo.Candidates[1], streamInfo.Candidates[2]
+	if trailer.Format != "hls" || trailer.Duration != 10*time.Second {
+		t.Errorf("Unexpected trailer probe: %+v", trailer)
+	}
+	if broken.Error == "" {
+		t.Errorf("Expected the missing candidate to record an error, got %+v", broken)
+	}
+	if movie.Duration != 20*time.Minute || movie.Quality != "1080p" || movie.Height != 1080 {
+		t.Errorf("Unexpected movie probe: %+v", movie)
+	}
+
+	config := models.DefaultConfig()
+	config.SourceIndex = 1
+	streamInfo, err = New(config).ExtractFromIframe(server.URL + "/embed")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if streamInfo.ManifestURL != server.URL+"/trailer/index.m3u8" {
+		t.Errorf("Expected --source 1 to pick the trailer, got %s", streamInfo.ManifestURL)
+	}
+
+	config.SourceIndex = 4
+	if _, err := New(config).ExtractFromIframe(server.URL + "/embed"); err == nil {
+		t.Error("Expected an error for a source index past the candidates")
+	}
+}
+
+func TestLoadCandidate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/trailer/index.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXTINF:10.0,\nt0.ts\n#EXT-X-ENDLIST\n"))
+		case "/movie/index.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXTINF:600.0,\nm0.ts\n#EXTINF:600.0,\nm1.ts\n#EXT-X-ENDLIST\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// The page comes from standard input, so only the manifests can be
+	// fetched again.
+	page := `<script>var trailer = "/trailer/index.m3u8"; var movie = "/movie/index.m3u8";</script>`
+	ext := New(models.DefaultConfig())
+	streamInfo, err := ext.ExtractFromHTML(page, server.URL+"/embed")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if streamInfo.ManifestURL != server.URL+"/movie/index.m3u8" {
+		t.Fatalf("Expected the longest candidate, got %s", streamInfo.ManifestURL)
+	}
+
+	trailer, err := ext.LoadCandidate(streamInfo, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if trailer.ManifestURL != server.URL+"/trailer/index.m3u8" || len(trailer.Segments) != 1 || trailer.Duration != 10*time.Second {
+		t.Errorf("Expected the trailer to be loaded, got %+v", trailer)
+	}
+	if len(trailer.Candidates) != 2 {
+		t.Errorf("Expected the candidates to be kept, got %+v", trailer.Candidates)
+	}
+
+	if _, err := ext.LoadCandidate(streamInfo, 2); err == nil {
+		t.Error("Expected an error for a source that doesn't exist")
+	}
+}
@@ -0,0 +1,601 @@
+package extractor
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+type mpdManifest struct {
+	XMLName                   xml.Name `xml:"MPD"`
+	Type                      string   `xml:"type,attr"`
+	MediaPresentationDuration string   `xml:"mediaPresentationDuration,attr"`
+	BaseURL                   string   `xml:"BaseURL"`
+	ProgramInformation        struct {
+		Title string `xml:"Title"`
+	} `xml:"ProgramInformation"`
+	Periods []mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	ID             string             `xml:"id,attr"`
+	Duration       string             `xml:"duration,attr"`
+	BaseURL        string             `xml:"BaseURL"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	ID                string              `xml:"id,attr"`
+	ContentType       string              `xml:"contentType,attr"`
+	MimeType          string              `xml:"mimeType,attr"`
+	Codecs            string              `xml:"codecs,attr"`
+	Lang              string              `xml:"lang,attr"`
+	Label             string              `xml:"label,attr"`
+	BaseURL           string              `xml:"BaseURL"`
+	Roles             []mpdDescriptor     `xml:"Role"`
+	ContentProtection []mpdDescriptor     `xml:"ContentProtection"`
+	SegmentTemplate   *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList       *mpdSegmentList     `xml:"SegmentList"`
+	SegmentBase       *mpdSegmentBase     `xml:"SegmentBase"`
+	Representations   []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID                string              `xml:"id,attr"`
+	Bandwidth         int                 `xml:"bandwidth,attr"`
+	Width             int                 `xml:"width,attr"`
+	Height            int                 `xml:"height,attr"`
+	FrameRate         string              `xml:"frameRate,attr"`
+	MimeType          string              `xml:"mimeType,attr"`
+	Codecs            string              `xml:"codecs,attr"`
+	BaseURL           string              `xml:"BaseURL"`
+	ContentProtection []mpdDescriptor     `xml:"ContentProtection"`
+	SegmentTemplate   *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList       *mpdSegmentList     `xml:"SegmentList"`
+	SegmentBase       *mpdSegmentBase     `xml:"SegmentBase"`
+}
+
+type mpdDescriptor struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+type mpdSegmentTemplate struct {
+	Media          string              `xml:"media,attr"`
+	Initialization string              `xml:"initialization,attr"`
+	StartNumber    *int                `xml:"startNumber,attr"`
+	Timescale      int64               `xml:"timescale,attr"`
+	Duration       int64               `xml:"duration,attr"`
+	Timeline       *mpdSegmentTimeline `xml:"SegmentTimeline"`
+}
+
+type mpdSegmentTimeline struct {
+	S []mpdTimelineEntry `xml:"S"`
+}
+
+type mpdTimelineEntry struct {
+	T *int64 `xml:"t,attr"`
+	D int64  `xml:"d,attr"`
+	R int    `xml:"r,attr"`
+}
+
+type mpdSegmentList struct {
+	Timescale      int64           `xml:"timescale,attr"`
+	Duration       int64           `xml:"duration,attr"`
+	Initialization *mpdURL         `xml:"Initialization"`
+	SegmentURLs    []mpdSegmentURL `xml:"SegmentURL"`
+}
+
+type mpdSegmentBase struct {
+	IndexRange     string  `xml:"indexRange,attr"`
+	Initialization *mpdURL `xml:"Initialization"`
+}
+
+type mpdURL struct {
+	SourceURL string `xml:"sourceURL,attr"`
+	Range     string `xml:"range,attr"`
+}
+
+type mpdSegmentURL struct {
+	Media      string `xml:"media,attr"`
+	MediaRange string `xml:"mediaRange,attr"`
+}
+
+// dashRendition is one representation resolved against its adaptation set.
+type dashRendition struct {
+	adaptationSet  *mpdAdaptationSet
+	representation *mpdRepresentation
+	baseURL        string
+}
+
+func isDASHManifest(content string) bool {
+	return strings.Contains(content, "<MPD")
+}
+
+func (e *Extractor) parseDASHManifest(content, manifestURL string, streamInfo *models.StreamInfo) error {
+	var manifest mpdManifest
+	if err := xml.Unmarshal([]byte(content), &manifest); err != nil {
+		return fmt.Errorf("invalid MPD: %w", err)
+	}
+
+	if manifest.Type == "dynamic" {
+		return fmt.Errorf("live DASH manifests are not supported")
+	}
+
+	if len(manifest.Periods) == 0 {
+		return fmt.Errorf("no periods found in MPD")
+	}
+
+	totalDuration, _ := parseISODuration(manifest.MediaPresentationDuration)
+	mpdBaseURL := resolveURL(manifestURL, manifest.BaseURL)
+
+	video := &models.Track{Type: "video"}
+	var audioTracks []models.Track
+	var variants []models.Variant
+
+	for periodIndex, period := range manifest.Periods {
+		periodDuration, err := parseISODuration(period.Duration)
+		if err != nil || periodDuration == 0 {
+			periodDuration = totalDuration
+			if len(manifest.Periods) > 1 {
+				periodDuration = 0
+			}
+		}
+
+		periodBaseURL := resolveURL(mpdBaseURL, period.BaseURL)
+
+		var videoRenditions []dashRendition
+		var audioSets []*mpdAdaptationSet
+
+		for i := range period.AdaptationSets {
+			set := &period.AdaptationSets[i]
+			switch adaptationSetType(set) {
+			case "video":
+				for j := range set.Representations {
+					videoRenditions = append(videoRenditions, dashRendition{
+						adaptationSet:  set,
+						representation: &set.Representations[j],
+						baseURL:        resolveURL(resolveURL(periodBaseURL, set.BaseURL), set.Representations[j].BaseURL),
+					})
+				}
+			case "audio":
+				audioSets = append(audioSets, set)
+			}
+		}
+
+		if len(videoRenditions) > 0 {
+			periodVariants := make([]models.Variant, len(videoRenditions))
+			for i, rendition := range videoRenditions {
+				periodVariants[i] = rendition.variant()
+			}
+
+			selected, err := selectVariant(periodVariants, e.config.Quality)
+			if err != nil {
+				return fmt.Errorf("failed to select representation: %w", err)
+			}
+
+			rendition := videoRenditions[0]
+			for i, variant := range periodVariants {
+				if variant == selected {
+					rendition = videoRenditions[i]
+					break
+				}
+			}
+
+			if err := e.appendDASHSegments(video, rendition, periodDuration, ""); err != nil {
+				return err
+			}
+
+			if periodIndex == 0 {
+				variants = periodVariants
+				streamInfo.Quality = selected.Label()
+			}
+		}
+
+		for i, set := range audioSets {
+			if len(set.Representations) == 0 {
+				continue
+			}
+
+			best := 0
+			for j, representation := range set.Representations {
+				if representation.Bandwidth > set.Representations[best].Bandwidth {
+					best = j
+				}
+			}
+
+			rendition := dashRendition{
+				adaptationSet:  set,
+				representation: &set.Representations[best],
+				baseURL:        resolveURL(resolveURL(periodBaseURL, set.BaseURL), set.Representations[best].BaseURL),
+			}
+
+			if periodIndex == 0 {
+				audioTracks = append(audioTracks, models.Track{
+					Type:      "audio",
+					Language:  set.Lang,
+					Name:      set.Label,
+					Default:   hasRole(set, "main"),
+					URL:       manifestURL,
+					Bandwidth: rendition.representation.Bandwidth,
+					Codecs:    rendition.codecs(),
+				})
+			}
+
+			if i >= len(audioTracks) {
+				continue
+			}
+
+			prefix := fmt.Sprintf("audio%d_", i)
+			if err := e.appendDASHSegments(&audioTracks[i], rendition, periodDuration, prefix); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(video.Segments) == 0 && len(audioTracks) == 0 {
+		return fmt.Errorf("no playable representations found in MPD")
+	}
+
+	streamInfo.Format = "dash"
+	if streamInfo.Title == "" {
+		streamInfo.Title = cleanTitle(manifest.ProgramInformation.Title)
+	}
+	streamInfo.Variants = variants
+	streamInfo.AudioTracks = audioTracks
+
+	if len(video.Segments) > 0 {
+		streamInfo.Segments = video.Segments
+		streamInfo.InitSegments = video.InitSegments
+
+		streamInfo.Audio = selectAudioTrack(audioTracks, "", e.config.AudioLang)
+	} else {
+		audio := selectAudioTrack(audioTracks, "", e.config.AudioLang)
+		streamInfo.Segments = audio.Segments
+		streamInfo.InitSegments = audio.InitSegments
+	}
+
+	var duration float64
+	for _, segment := range streamInfo.Segments {
+		duration += segment.Duration
+	}
+	streamInfo.Duration = time.Duration(duration * float64(time.Second))
+
+	return nil
+}
+
+func adaptationSetType(set *mpdAdaptationSet) string {
+	contentType := set.ContentType
+	if contentType == "" {
+		mimeType := set.MimeType
+		if mimeType == "" && len(set.Representations) > 0 {
+			mimeType = set.Representations[0].MimeType
+		}
+		contentType, _, _ = strings.Cut(mimeType, "/")
+	}
+
+	switch contentType {
+	case "video", "audio", "text":
+		return contentType
+	case "application":
+		return "text"
+	}
+	return ""
+}
+
+func hasRole(set *mpdAdaptationSet, value string) bool {
+	for _, role := range set.Roles {
+		if role.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (r dashRendition) codecs() string {
+	if r.representation.Codecs != "" {
+		return r.representation.Codecs
+	}
+	return r.adaptationSet.Codecs
+}
+
+func (r dashRendition) variant() models.Variant {
+	variant := models.Variant{
+		URL:       r.baseURL,
+		Bandwidth: r.representation.Bandwidth,
+		Width:     r.representation.Width,
+		Height:    r.representation.Height,
+		Codecs:    r.codecs(),
+	}
+
+	if numerator, denominator, ok := strings.Cut(r.representation.FrameRate, "/"); ok {
+		n, _ := strconv.ParseFloat(numerator, 64)
+		d, _ := strconv.ParseFloat(denominator, 64)
+		if d > 0 {
+			variant.FrameRate = n / d
+		}
+	} else {
+		variant.FrameRate, _ = strconv.ParseFloat(r.representation.FrameRate, 64)
+	}
+
+	return variant
+}
+
+func (e *Extractor) appendDASHSegments(track *models.Track, rendition dashRendition, periodDuration time.Duration, prefix string) error {
+	if len(rendition.adaptationSet.ContentProtection) > 0 || len(rendition.representation.ContentProtection) > 0 {
+		return fmt.Errorf("DRM protected representations are not supported")
+	}
+
+	representation := rendition.representation
+	template := mergeSegmentTemplates(rendition.adaptationSet.SegmentTemplate, representation.SegmentTemplate)
+	segmentList := representation.SegmentList
+	if segmentList == nil {
+		segmentList = rendition.adaptationSet.SegmentList
+	}
+
+	addInit := func(initURL string, byteRange string) error {
+		init := models.Segment{
+			URL:      initURL,
+			Index:    len(track.InitSegments),
+			Filename: fmt.Sprintf("%sinit_%02d%s", prefix, len(track.InitSegments), segmentExtension(initURL, ".mp4")),
+		}
+		if byteRange != "" {
+			offset, length, err := parseDASHRange(byteRange)
+			if err != nil {
+				return err
+			}
+			init.ByteOffset = offset
+			init.ByteLength = length
+		}
+		track.InitSegments = append(track.InitSegments, init)
+		return nil
+	}
+
+	addSegment := func(segmentURL string, duration float64, byteRange string) error {
+		index := len(track.Segments)
+		segment := models.Segment{
+			URL:      segmentURL,
+			Index:    index,
+			Sequence: index,
+			Duration: duration,
+			Filename: fmt.Sprintf("%ssegment_%04d%s", prefix, index, segmentExtension(segmentURL, ".m4s")),
+		}
+		if len(track.InitSegments) > 0 {
+			segment.InitFilename = track.InitSegments[len(track.InitSegments)-1].Filename
+		}
+		if index > 0 {
+			previous := track.Segments[index-1]
+			segment.Offset = previous.Offset + previous.Duration
+		}
+		if byteRange != "" {
+			offset, length, err := parseDASHRange(byteRange)
+			if err != nil {
+				return err
+			}
+			segment.ByteOffset = offset
+			segment.ByteLength = length
+		}
+		track.Segments = append(track.Segments, segment)
+		return nil
+	}
+
+	switch {
+	case template != nil && template.Media != "":
+		timescale := template.Timescale
+		if timescale == 0 {
+			timescale = 1
+		}
+
+		startNumber := 1
+		if template.StartNumber != nil {
+			startNumber = *template.StartNumber
+		}
+
+		if template.Initialization != "" {
+			initURL := resolveURL(rendition.baseURL, expandTemplate(template.Initialization, representation, 0, 0))
+			if err := addInit(initURL, ""); err != nil {
+				return err
+			}
+		}
+
+		if template.Timeline != nil {
+			number := startNumber
+			var t int64
+			periodEnd := int64(periodDuration.Seconds() * float64(timescale))
+
+			for i, entry := range template.Timeline.S {
+				if entry.T != nil {
+					t = *entry.T
+				}
+
+				repeat := entry.R
+				if repeat < 0 {
+					end := periodEnd
+					if i+1 < len(template.Timeline.S) && template.Timeline.S[i+1].T != nil {
+						end = *template.Timeline.S[i+1].T
+					}
+					if entry.D <= 0 || end <= t {
+						repeat = 0
+					} else {
+						repeat = int(math.Ceil(float64(end-t)/float64(entry.D))) - 1
+					}
+				}
+
+				for r := 0; r <= repeat; r++ {
+					segmentURL := resolveURL(rendition.baseURL, expandTemplate(template.Media, representation, number, t))
+					if err := addSegment(segmentURL, float64(entry.D)/float64(timescale), ""); err != nil {
+						return err
+					}
+					t += entry.D
+					number++
+				}
+			}
+			return nil
+		}
+
+		if template.Duration <= 0 {
+			return fmt.Errorf("SegmentTemplate without duration or SegmentTimeline")
+		}
+		if periodDuration <= 0 {
+			return fmt.Errorf("cannot determine segment count without a period duration")
+		}
+
+		segmentDuration := float64(template.Duration) / float64(timescale)
+		count := int(math.Ceil(periodDuration.Seconds() / segmentDuration))
+		remaining := periodDuration.Seconds()
+
+		for i := 0; i < count; i++ {
+			number := startNumber + i
+			duration := math.Min(segmentDuration, remaining)
+			remaining -= duration
+			segmentURL := resolveURL(rendition.baseURL, expandTemplate(template.Media, representation, number, int64(i)*template.Duration))
+			if err := addSegment(segmentURL, duration, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case segmentList != nil:
+		if segmentList.Initialization != nil {
+			initURL := rendition.baseURL
+			if segmentList.Initialization.SourceURL != "" {
+				initURL = resolveURL(rendition.baseURL, segmentList.Initialization.SourceURL)
+			}
+			if err := addInit(initURL, segmentList.Initialization.Range); err != nil {
+				return err
+			}
+		}
+
+		timescale := segmentList.Timescale
+		if timescale == 0 {
+			timescale = 1
+		}
+		segmentDuration := float64(segmentList.Duration) / float64(timescale)
+
+		for _, segmentURL := range segmentList.SegmentURLs {
+			mediaURL := rendition.baseURL
+			if segmentURL.Media != "" {
+				mediaURL = resolveURL(rendition.baseURL, segmentURL.Media)
+			}
+			if err := addSegment(mediaURL, segmentDuration, segmentURL.MediaRange); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		// SegmentBase, or a bare BaseURL: the representation is a single
+		// self-initializing file that is downloaded as one segment.
+		if rendition.baseURL == "" {
+			return fmt.Errorf("representation %s has no segment information", representation.ID)
+		}
+		return addSegment(rendition.baseURL, periodDuration.Seconds(), "")
+	}
+}
+
+func mergeSegmentTemplates(parent, child *mpdSegmentTemplate) *mpdSegmentTemplate {
+	if parent == nil {
+		return child
+	}
+	if child == nil {
+		return parent
+	}
+
+	merged := *parent
+	if child.Media != "" {
+		merged.Media = child.Media
+	}
+	if child.Initialization != "" {
+		merged.Initialization = child.Initialization
+	}
+	if child.StartNumber != nil {
+		merged.StartNumber = child.StartNumber
+	}
+	if child.Timescale != 0 {
+		merged.Timescale = child.Timescale
+	}
+	if child.Duration != 0 {
+		merged.Duration = child.Duration
+	}
+	if child.Timeline != nil {
+		merged.Timeline = child.Timeline
+	}
+	return &merged
+}
+
+var templateIdentifier = regexp.MustCompile(`\$(RepresentationID|Number|Time|Bandwidth)(%0\d+d)?\$`)
+
+func expandTemplate(template string, representation *mpdRepresentation, number int, t int64) string {
+	expanded := templateIdentifier.ReplaceAllStringFunc(template, func(match string) string {
+		parts := templateIdentifier.FindStringSubmatch(match)
+		format := parts[2]
+		if format == "" {
+			format = "%d"
+		}
+
+		switch parts[1] {
+		case "RepresentationID":
+			return representation.ID
+		case "Number":
+			return fmt.Sprintf(format, number)
+		case "Time":
+			return fmt.Sprintf(format, t)
+		case "Bandwidth":
+			return fmt.Sprintf(format, representation.Bandwidth)
+		}
+		return match
+	})
+
+	return strings.ReplaceAll(expanded, "$$", "$")
+}
+
+// parseDASHRange converts an inclusive "first-last" byte range into an offset
+// and length.
+func parseDASHRange(value string) (int64, int64, error) {
+	firstStr, lastStr, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid byte range: %s", value)
+	}
+
+	first, err := strconv.ParseInt(firstStr, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid byte range: %s", value)
+	}
+	last, err := strconv.ParseInt(lastStr, 10, 64)
+	if err != nil || last < first {
+		return 0, 0, fmt.Errorf("invalid byte range: %s", value)
+	}
+
+	return first, last - first + 1, nil
+}
+
+var isoDurationPattern = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+func parseISODuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	matches := isoDurationPattern.FindStringSubmatch(value)
+	if matches == nil {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var total float64
+	for i, unit := range units {
+		if matches[i+1] == "" {
+			continue
+		}
+		n, _ := strconv.ParseFloat(matches[i+1], 64)
+		total += n * float64(unit)
+	}
+
+	return time.Duration(total), nil
+}
@@ -0,0 +1,278 @@
+package extractor
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+func TestParseISODuration(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{value: "PT30S", expected: 30 * time.Second},
+		{value: "PT1M59.5S", expected: 119500 * time.Millisecond},
+		{value: "PT1H2M3S", expected: time.Hour + 2*time.Minute + 3*time.Second},
+		{value: "P1DT1H", expected: 25 * time.Hour},
+		{value: "", expected: 0},
+	}
+
+	for _, test := range tests {
+		result, err := parseISODuration(test.value)
+		if err != nil {
+			t.Errorf("parseISODuration(%q) returned error: %v", test.value, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("parseISODuration(%q) = %v, expected %v", test.value, result, test.expected)
+		}
+	}
+
+	if _, err := parseISODuration("1 hour"); err == nil {
+		t.Error("Expected error for invalid duration")
+	}
+}
+
+func TestParseDASHSegmentTemplateNumber(t *testing.T) {
+	config := models.DefaultConfig()
+	config.Quality = "720p"
+	ext := New(config)
+
+	manifest := `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT25S">
+  <Period>
+    <AdaptationSet contentType="video" mimeType="video/mp4">
+      <SegmentTemplate media="$RepresentationID$/seg-$Number%05d$.m4s" initialization="$RepresentationID$/init.mp4" startNumber="1" timescale="1000" duration="10000"/>
+      <Representation id="v1080" bandwidth="5000000" width="1920" height="1080" codecs="avc1.640028"/>
+      <Representation id="v720" bandwidth="2500000" width="1280" height="720" codecs="avc1.4d401f" frameRate="30000/1001"/>
+    </AdaptationSet>
+    <AdaptationSet contentType="audio" mimeType="audio/mp4" lang="en">
+      <Role schemeIdUri="urn:mpeg:dash:role:2011" value="main"/>
+      <SegmentTemplate media="audio/$Number$.m4s" initialization="audio/init.mp4" timescale="48000" duration="480000"/>
+      <Representation id="a128" bandwidth="128000" codecs="mp4a.40.2"/>
+      <Representation id="a64" bandwidth="64000" codecs="mp4a.40.2"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+	streamInfo := &models.StreamInfo{}
+	if err := ext.parseDASHManifest(manifest, "https://example.com/dash/manifest.mpd", streamInfo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if streamInfo.Format != "dash" {
+		t.Errorf("Format = %s, expected dash", streamInfo.Format)
+	}
+
+	if len(streamInfo.Variants) != 2 || streamInfo.Quality != "720p" {
+		t.Errorf("Expected 2 variants with 720p selected, got %d variants and %s", len(streamInfo.Variants), streamInfo.Quality)
+	}
+
+	expectedURLs := []string{
+		"https://example.com/dash/v720/seg-00001.m4s",
+		"https://example.com/dash/v720/seg-00002.m4s",
+		"https://example.com/dash/v720/seg-00003.m4s",
+	}
+
+	if len(streamInfo.Segments) != len(expectedURLs) {
+		t.Fatalf("Expected %d video segments, got %d", len(expectedURLs), len(streamInfo.Segments))
+	}
+
+	for i, segment := range streamInfo.Segments {
+		if segment.URL != expectedURLs[i] {
+			t.Errorf("Segment %d URL = %s, expected %s", i, segment.URL, expectedURLs[i])
+		}
+		if segment.InitFilename != "init_00.mp4" {
+			t.Errorf("Segment %d InitFilename = %s, expected init_00.mp4", i, segment.InitFilename)
+		}
+	}
+
+	if streamInfo.Segments[2].Duration != 5 {
+		t.Errorf("Last segment duration = %v, expected 5", streamInfo.Segments[2].Duration)
+	}
+
+	if streamInfo.InitSegments[0].URL != "https://example.com/dash/v720/init.mp4" {
+		t.Errorf("Init URL = %s", streamInfo.InitSegments[0].URL)
+	}
+
+	if streamInfo.Duration != 25*time.Second {
+		t.Errorf("Duration = %v, expected 25s", streamInfo.Duration)
+	}
+
+	if streamInfo.Audio == nil {
+		t.Fatal("Expected an audio track to be selected")
+	}
+
+	audio := streamInfo.Audio
+	if audio.Language != "en" || !audio.Default || audio.Bandwidth != 128000 {
+		t.Errorf("Audio track = %+v", audio)
+	}
+
+	if len(audio.Segments) != 3 || audio.Segments[0].URL != "https://example.com/dash/audio/1.m4s" {
+		t.Errorf("Unexpected audio segments: %+v", audio.Segments)
+	}
+
+	if audio.Segments[0].Filename != "audio0_segment_0000.m4s" || audio.Segments[0].InitFilename != "audio0_init_00.mp4" {
+		t.Errorf("Audio filenames = %s / %s", audio.Segments[0].Filename, audio.Segments[0].InitFilename)
+	}
+}
+
+func TestParseDASHSegmentTimeline(t *testing.T) {
+	ext := New(models.DefaultConfig())
+
+	manifest := `<MPD type="static" mediaPresentationDuration="PT8S">
+  <BaseURL>https://cdn.example.com/content/</BaseURL>
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <Representation id="video" bandwidth="1000000" width="640" height="360">
+        <SegmentTemplate media="$RepresentationID$-$Time$.m4s" initialization="$RepresentationID$-init.mp4" timescale="90000">
+          <SegmentTimeline>
+            <S t="1000" d="180000" r="2"/>
+            <S d="90000"/>
+          </SegmentTimeline>
+        </SegmentTemplate>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+	streamInfo := &models.StreamInfo{}
+	if err := ext.parseDASHManifest(manifest, "https://example.com/manifest.mpd", streamInfo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"https://cdn.example.com/content/video-1000.m4s",
+		"https://cdn.example.com/content/video-181000.m4s",
+		"https://cdn.example.com/content/video-361000.m4s",
+		"https://cdn.example.com/content/video-541000.m4s",
+	}
+
+	if len(streamInfo.Segments) != len(expected) {
+		t.Fatalf("Expected %d segments, got %d", len(expected), len(streamInfo.Segments))
+	}
+
+	for i, segment := range streamInfo.Segments {
+		if segment.URL != expected[i] {
+			t.Errorf("Segment %d URL = %s, expected %s", i, segment.URL, expected[i])
+		}
+	}
+
+	if streamInfo.Audio != nil {
+		t.Error("Expected no audio track")
+	}
+}
+
+func TestParseDASHSegmentListAndBase(t *testing.T) {
+	ext := New(models.DefaultConfig())
+
+	manifest := `<MPD type="static" mediaPresentationDuration="PT20S">
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <Representation id="v" bandwidth="800000" height="480">
+        <BaseURL>video.mp4</BaseURL>
+        <SegmentList timescale="1" duration="10">
+          <Initialization range="0-799"/>
+          <SegmentURL mediaRange="800-50799"/>
+          <SegmentURL mediaRange="50800-99999"/>
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4" lang="de">
+      <Representation id="a" bandwidth="96000">
+        <BaseURL>audio_de.mp4</BaseURL>
+        <SegmentBase indexRange="700-899">
+          <Initialization range="0-699"/>
+        </SegmentBase>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+	streamInfo := &models.StreamInfo{}
+	if err := ext.parseDASHManifest(manifest, "https://example.com/vod/manifest.mpd", streamInfo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	init := streamInfo.InitSegments[0]
+	if init.URL != "https://example.com/vod/video.mp4" || init.ByteOffset != 0 || init.ByteLength != 800 {
+		t.Errorf("Init segment = %+v", init)
+	}
+
+	expected := []struct {
+		offset int64
+		length int64
+	}{
+		{offset: 800, length: 50000},
+		{offset: 50800, length: 49200},
+	}
+
+	for i, segment := range streamInfo.Segments {
+		if segment.ByteOffset != expected[i].offset || segment.ByteLength != expected[i].length {
+			t.Errorf("Segment %d range = %d@%d, expected %d@%d", i, segment.ByteLength, segment.ByteOffset, expected[i].length, expected[i].offset)
+		}
+	}
+
+	if streamInfo.Audio == nil || len(streamInfo.Audio.Segments) != 1 {
+		t.Fatalf("Expected single-file audio track, got %+v", streamInfo.Audio)
+	}
+
+	if streamInfo.Audio.Segments[0].URL != "https://example.com/vod/audio_de.mp4" || streamInfo.Audio.Segments[0].ByteLength != 0 {
+		t.Errorf("Audio segment = %+v", streamInfo.Audio.Segments[0])
+	}
+}
+
+func TestParseDASHRejectsDRM(t *testing.T) {
+	ext := New(models.DefaultConfig())
+
+	manifest := `<MPD type="static" mediaPresentationDuration="PT10S">
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <ContentProtection schemeIdUri="urn:mpeg:dash:mp4protection:2011" value="cenc"/>
+      <Representation id="v" bandwidth="800000"><BaseURL>v.mp4</BaseURL></Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+	if err := ext.parseDASHManifest(manifest, "https://example.com/manifest.mpd", &models.StreamInfo{}); err == nil {
+		t.Error("Expected error for DRM protected manifest")
+	}
+}
+
+func TestExtractFromIframeDASH(t *testing.T) {
+	var serverURL string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/embed":
+			fmt.Fprintf(w, `<script>player.load({manifest: "%s/stream/manifest.mpd"});</script>`, serverURL)
+		case "/stream/manifest.mpd":
+			w.Write([]byte(`<MPD type="static" mediaPresentationDuration="PT4S"><Period>
+<AdaptationSet mimeType="video/mp4"><Representation id="v" bandwidth="1" height="240">
+<SegmentTemplate media="$Number$.m4s" initialization="init.mp4" duration="2"/></Representation></AdaptationSet>
+</Period></MPD>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	serverURL = server.URL
+
+	ext := New(models.DefaultConfig())
+	streamInfo, err := ext.ExtractFromIframe(server.URL + "/embed")
+	if err != nil {
+		t.Fatalf("ExtractFromIframe failed: %v", err)
+	}
+
+	if streamInfo.ManifestURL != server.URL+"/stream/manifest.mpd" {
+		t.Errorf("ManifestURL = %s", streamInfo.ManifestURL)
+	}
+
+	if len(streamInfo.Segments) != 2 || streamInfo.Segments[1].URL != server.URL+"/stream/2.m4s" {
+		t.Errorf("Unexpected segments: %+v", streamInfo.Segments)
+	}
+}
@@ -0,0 +1,172 @@
+package extractor
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const maxDeobfuscationPasses = 3
+
+var (
+	packerPattern  = regexp.MustCompile(`(?s)}\s*\(\s*(?:'((?:\\.|[^'\\])*)'|"((?:\\.|[^"\\])*)")\s*,\s*(\d+)\s*,\s*(\d+)\s*,\s*(?:'((?:\\.|[^'\\])*)'|"((?:\\.|[^"\\])*)")\.split\(\s*['"]\|['"]\s*\)`)
+	packerWord     = regexp.MustCompile(`\b\w+\b`)
+	atobPattern    = regexp.MustCompile(`atob\(\s*['"]([A-Za-z0-9+/=_-]+)['"]\s*\)`)
+	literalPattern = regexp.MustCompile(`'([^'\s]{16,})'|"([^"\s]{16,})"`)
+	base64Pattern  = regexp.MustCompile(`^[A-Za-z0-9+/_-]+={0,2}$`)
+	hexPattern     = regexp.MustCompile(`^(?:[0-9a-fA-F]{2})+$`)
+)
+
+// deobfuscate returns the payloads hidden in packed or encoded script
+// content: p.a.c.k.e.r. eval blocks, atob() arguments and base64, hex or
+// URL-encoded string literals. Decoded payloads are scanned again so that
+// layered obfuscation is unwrapped as well.
+func deobfuscate(content string) []string {
+	var results []string
+	seen := make(map[string]bool)
+	pending := []string{content}
+
+	for pass := 0; pass < maxDeobfuscationPasses && len(pending) > 0; pass++ {
+		var next []string
+		for _, source := range pending {
+			for _, decoded := range decodeLayer(source) {
+				if seen[decoded] {
+					continue
+				}
+				seen[decoded] = true
+				results = append(results, decoded)
+				next = append(next, decoded)
+			}
+		}
+		pending = next
+	}
+
+	return results
+}
+
+func decodeLayer(content string) []string {
+	var decoded []string
+
+	for _, matches := range packerPattern.FindAllStringSubmatch(content, -1) {
+		if unpacked, ok := unpackPacker(matches); ok {
+			decoded = append(decoded, unpacked)
+		}
+	}
+
+	for _, matches := range atobPattern.FindAllStringSubmatch(content, -1) {
+		if text, ok := decodeBase64(matches[1]); ok {
+			decoded = append(decoded, text)
+		}
+	}
+
+	for _, matches := range literalPattern.FindAllStringSubmatch(content, -1) {
+		literal := matches[1] + matches[2]
+		if text, ok := decodeLiteral(literal); ok && looksLikeMediaSource(text) {
+			decoded = append(decoded, text)
+		}
+	}
+
+	return decoded
+}
+
+// unpackPacker reverses Dean Edwards' packer: every word in the payload is a
+// number in the given base indexing into the keyword list.
+func unpackPacker(matches []string) (string, bool) {
+	payload := unescapePackerString(matches[1] + matches[2])
+	radix, err := strconv.Atoi(matches[3])
+	if err != nil || radix < 2 || radix > 62 {
+		return "", false
+	}
+	count, err := strconv.Atoi(matches[4])
+	if err != nil {
+		return "", false
+	}
+	keywords := strings.Split(unescapePackerString(matches[5]+matches[6]), "|")
+	if len(keywords) < count {
+		count = len(keywords)
+	}
+
+	unpacked := packerWord.ReplaceAllStringFunc(payload, func(word string) string {
+		index, ok := decodePackerNumber(word, radix)
+		if !ok || index >= count || keywords[index] == "" {
+			return word
+		}
+		return keywords[index]
+	})
+
+	return unpacked, true
+}
+
+func decodePackerNumber(word string, radix int) (int, bool) {
+	const alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+	value := 0
+	for _, r := range word {
+		digit := strings.IndexRune(alphabet, r)
+		if digit < 0 || digit >= radix {
+			return 0, false
+		}
+		value = value*radix + digit
+	}
+	return value, true
+}
+
+func unescapePackerString(s string) string {
+	replacer := strings.NewReplacer(`\\`, `\`, `\'`, `'`, `\"`, `"`)
+	return replacer.Replace(s)
+}
+
+func decodeLiteral(literal string) (string, bool) {
+	if strings.Contains(literal, "%") {
+		if text, err := url.QueryUnescape(literal); err == nil && text != literal && isPrintable(text) {
+			return text, true
+		}
+	}
+	if hexPattern.MatchString(literal) {
+		if data, err := hex.DecodeString(literal); err == nil && isPrintable(string(data)) {
+			return string(data), true
+		}
+	}
+	return decodeBase64(literal)
+}
+
+func decodeBase64(encoded string) (string, bool) {
+	if !base64Pattern.MatchString(encoded) {
+		return "", false
+	}
+
+	encodings := []*base64.Encoding{
+		base64.StdEncoding,
+		base64.URLEncoding,
+		base64.RawStdEncoding,
+		base64.RawURLEncoding,
+	}
+
+	for _, encoding := range encodings {
+		data, err := encoding.DecodeString(encoded)
+		if err == nil && len(data) > 0 && isPrintable(string(data)) {
+			return string(data), true
+		}
+	}
+	return "", false
+}
+
+func looksLikeMediaSource(text string) bool {
+	lower := strings.ToLower(text)
+	return strings.Contains(lower, ".m3u8") || strings.Contains(lower, ".mpd") ||
+		strings.Contains(lower, "http://") || strings.Contains(lower, "https://") ||
+		strings.Contains(lower, "eval(function(p,a,c,k,e,")
+}
+
+func isPrintable(text string) bool {
+	for _, r := range text {
+		if r == unicode.ReplacementChar || (!unicode.IsPrint(r) && !unicode.IsSpace(r)) {
+			return false
+		}
+	}
+	return true
+}
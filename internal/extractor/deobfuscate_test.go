@@ -0,0 +1,96 @@
+package extractor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestUnpackPacker(t *testing.T) {
+	content := readFixture(t, "packed.html")
+
+	decoded := deobfuscate(content)
+	if len(decoded) == 0 {
+		t.Fatal("Expected packed script to be unpacked")
+	}
+
+	expected := "player.setup({sources:[{file:'https://cdn.example.com/hls/abc123/master.m3u8?token=xyz'}]"
+	if !strings.Contains(decoded[0], expected) {
+		t.Errorf("Unpacked script %q does not contain %q", decoded[0], expected)
+	}
+}
+
+func TestFindManifestURLObfuscated(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		expected string
+	}{
+		{fixture: "packed.html", expected: "https://cdn.example.com/hls/abc123/master.m3u8?token=xyz"},
+		{fixture: "base64.html", expected: "https://cdn.example.com/hls/b64/index.m3u8"},
+		{fixture: "hex.html", expected: "https://cdn.example.com/dash/hex/manifest.mpd"},
+		{fixture: "urlencoded.html", expected: "https://cdn.example.com/hls/encoded/playlist.m3u8"},
+		{fixture: "layered.html", expected: "https://cdn.example.com/hls/layered/master.m3u8"},
+	}
+
+	e := New(models.DefaultConfig())
+	for _, test := range tests {
+		content := readFixture(t, test.fixture)
+		result, err := e.findManifestURL(content, "https://player.example.com/embed/1")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.fixture, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.fixture, test.expected, result)
+		}
+	}
+}
+
+func TestDeobfuscateIgnoresPlainLiterals(t *testing.T) {
+	content := `<script>var id = "abcdefghijklmnopqrstuvwx"; var hash = "0123456789abcdef0123";</script>`
+
+	if decoded := deobfuscate(content); len(decoded) != 0 {
+		t.Errorf("Expected no decoded payloads, got %q", decoded)
+	}
+}
+
+func TestExtractFromIframeFollowsPackedIframe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/embed":
+			w.Write([]byte(readFixture(t, "packed_iframe.html")))
+		case "/player":
+			w.Write([]byte(`<script>var src = "/hls/index.m3u8";</script>`))
+		case "/hls/index.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10.0,\nsegment0.ts\n#EXT-X-ENDLIST\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	e := New(models.DefaultConfig())
+	streamInfo, err := e.ExtractFromIframe(server.URL + "/embed")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if streamInfo.ManifestURL != server.URL+"/hls/index.m3u8" {
+		t.Errorf("Expected manifest from the unpacked iframe, got %s", streamInfo.ManifestURL)
+	}
+}
@@ -0,0 +1,276 @@
+package extractor
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// Kinds of input URL. A page is crawled for a manifest; the others are used
+// as they are.
+const (
+	kindPage        = ""
+	kindHLS         = "hls"
+	kindDASH        = "dash"
+	kindProgressive = "progressive"
+)
+
+// sniffSize is how much of a response body is read to tell a document or
+// manifest from a media file served without a telling Content-Type.
+const sniffSize = 64 << 10
+
+// progressiveChunkSize is the size of the byte ranges a progressive file is
+// split into, so that the downloader can fetch them in parallel.
+const progressiveChunkSize = 8 << 20
+
+var manifestExtensions = map[string]string{
+	".m3u8": kindHLS,
+	".m3u":  kindHLS,
+	".mpd":  kindDASH,
+}
+
+var mediaExtensions = map[string]bool{
+	".mp4":  true,
+	".m4v":  true,
+	".m4a":  true,
+	".mov":  true,
+	".webm": true,
+	".mkv":  true,
+}
+
+var manifestContentTypes = map[string]string{
+	"application/vnd.apple.mpegurl": kindHLS,
+	"application/x-mpegurl":         kindHLS,
+	"audio/mpegurl":                 kindHLS,
+	"audio/x-mpegurl":               kindHLS,
+	"application/dash+xml":          kindDASH,
+}
+
+// kindFromURL guesses the kind of input from the extension of its path.
+func kindFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return kindPage
+	}
+
+	ext := strings.ToLower(path.Ext(u.Path))
+	if kind, ok := manifestExtensions[ext]; ok {
+		return kind
+	}
+	if mediaExtensions[ext] {
+		return kindProgressive
+	}
+	return kindPage
+}
+
+// kindFromContentType returns the kind of input a Content-Type header
+// announces, or kindPage for documents and unknown types.
+func kindFromContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return kindPage
+	}
+
+	if kind, ok := manifestContentTypes[mediaType]; ok {
+		return kind
+	}
+	if strings.HasPrefix(mediaType, "video/") || strings.HasPrefix(mediaType, "audio/") {
+		return kindProgressive
+	}
+	return kindPage
+}
+
+// kindFromResponse returns the kind of a fetched URL from its Content-Type,
+// falling back to the body for manifests served as text/plain or
+// application/octet-stream.
+func kindFromResponse(contentType, content string) string {
+	if kind := kindFromContentType(contentType); kind != kindPage {
+		return kind
+	}
+
+	trimmed := strings.TrimSpace(strings.TrimPrefix(content, "\ufeff"))
+	switch {
+	case strings.HasPrefix(trimmed, "#EXTM3U"):
+		return kindHLS
+	case strings.HasPrefix(trimmed, "<MPD"),
+		strings.HasPrefix(trimmed, "<?xml") && isDASHManifest(trimmed):
+		return kindDASH
+	}
+	return kindPage
+}
+
+// sniffBody reads a response body and returns it with its kind. A body
+// whose Content-Type announces a media file isn't read at all, and one whose
+// first sniffSize bytes are neither a manifest nor text is taken to be a
+// media file too and only that prefix is returned, so that a media file at
+// a URL without an extension and served as application/octet-stream isn't
+// loaded into memory.
+func sniffBody(contentType string, body io.Reader) ([]byte, string, error) {
+	if kindFromContentType(contentType) == kindProgressive {
+		return nil, kindProgressive, nil
+	}
+
+	prefix, err := io.ReadAll(io.LimitReader(body, sniffSize))
+	if err != nil {
+		return nil, "", err
+	}
+
+	kind := kindFromResponse(contentType, string(prefix))
+	if kind == kindPage && !strings.HasPrefix(http.DetectContentType(prefix), "text/") {
+		return prefix, kindProgressive, nil
+	}
+
+	rest, err := io.ReadAll(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return append(prefix, rest...), kind, nil
+}
+
+// extractDirect loads a URL that points straight at a manifest or a media
+// file, without crawling for it.
+func (e *Extractor) extractDirect(inputURL, kind string) (*models.StreamInfo, error) {
+	streamInfo := &models.StreamInfo{
+		IframeURL:   inputURL,
+		ManifestURL: inputURL,
+		Headers:     make(map[string]string),
+	}
+
+	var err error
+	if kind == kindProgressive {
+		err = e.loadProgressive(streamInfo, inputURL)
+	} else {
+		err = e.loadManifest(streamInfo)
+	}
+	if err != nil {
+		return nil, err
+	}
+	streamInfo.Headers = e.session.Headers()
+
+	return streamInfo, nil
+}
+
+// loadProgressive describes a plain media file as byte range chunks of the
+// same URL, which the downloader fetches in parallel and the merger joins
+// back together. Servers that don't support ranges get a single segment.
+func (e *Extractor) loadProgressive(streamInfo *models.StreamInfo, mediaURL string) error {
+	var referer string
+	if len(streamInfo.Chain) > 0 {
+		referer = streamInfo.Chain[len(streamInfo.Chain)-1]
+	}
+
+	size, finalURL, err := e.probeSize(mediaURL, referer)
+	if err != nil {
+		return fmt.Errorf("failed to fetch media file: %w", err)
+	}
+
+	streamInfo.ManifestURL = mediaURL
+	streamInfo.PlaylistURL = finalURL
+	streamInfo.BaseURL = e.getBaseURL(finalURL)
+	streamInfo.Format = kindProgressive
+
+	name, ext := mediaFileName(finalURL)
+	if streamInfo.Title == "" {
+		streamInfo.Title = name
+	}
+
+	if size <= 0 {
+		streamInfo.Segments = []models.Segment{{
+			URL:      finalURL,
+			Filename: "chunk_0000" + ext,
+		}}
+		if e.config.Verbose {
+			fmt.Println("Server doesn't support byte ranges, downloading the file in one piece")
+		}
+		return nil
+	}
+
+	for offset := int64(0); offset < size; offset += progressiveChunkSize {
+		length := int64(progressiveChunkSize)
+		if offset+length > size {
+			length = size - offset
+		}
+
+		index := len(streamInfo.Segments)
+		streamInfo.Segments = append(streamInfo.Segments, models.Segment{
+			URL:        finalURL,
+			Index:      index,
+			Filename:   fmt.Sprintf("chunk_%04d%s", index, ext),
+			ByteOffset: offset,
+			ByteLength: length,
+		})
+	}
+
+	if e.config.Verbose {
+		fmt.Printf("Media file is %d bytes, downloading it in %d chunks\n", size, len(streamInfo.Segments))
+	}
+
+	return nil
+}
+
+// probeSize requests the first byte of a media file and returns its total
+// size from the Content-Range of the response, along with the final URL after
+// redirects. The size is 0 when the server ignores the range or doesn't
+// know the size.
+func (e *Extractor) probeSize(mediaURL, referer string) (int64, string, error) {
+	req, err := e.newRequest(mediaURL, referer)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	finalURL := resp.Request.URL.String()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return contentRangeSize(resp.Header.Get("Content-Range")), finalURL, nil
+	case http.StatusOK:
+		return 0, finalURL, nil
+	default:
+		return 0, "", fmt.Errorf("HTTP error: %d %s", resp.StatusCode, resp.Status)
+	}
+}
+
+// contentRangeSize returns the complete length from a "bytes 0-0/1234"
+// Content-Range header, or 0 when it is missing or unknown.
+func contentRangeSize(contentRange string) int64 {
+	slash := strings.LastIndex(contentRange, "/")
+	if slash < 0 {
+		return 0
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(contentRange[slash+1:]), 10, 64)
+	if err != nil || size < 0 {
+		return 0
+	}
+	return size
+}
+
+// mediaFileName returns the unescaped base name of a media URL without its
+// extension, and the extension, ".mp4" if the URL has no known one.
+func mediaFileName(mediaURL string) (string, string) {
+	u, err := url.Parse(mediaURL)
+	if err != nil {
+		return "", ".mp4"
+	}
+
+	base := path.Base(u.Path)
+	ext := strings.ToLower(path.Ext(base))
+	if !mediaExtensions[ext] {
+		return "", ".mp4"
+	}
+	return strings.TrimSuffix(base, path.Ext(base)), ext
+}
@@ -0,0 +1,177 @@
+package extractor
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+func TestKindFromURL(t *testing.T) {
+	tests := map[string]string{
+		"https://cdn.example.com/hls/master.m3u8?token=abc": kindHLS,
+		"https://cdn.example.com/dash/stream.MPD":           kindDASH,
+		"https://cdn.example.com/files/movie.mp4":           kindProgressive,
+		"https://example.com/embed/12345":                   kindPage,
+		"https://example.com/watch.php?file=movie.mp4":      kindPage,
+	}
+
+	for input, expected := range tests {
+		if kind := kindFromURL(input); kind != expected {
+			t.Errorf("kindFromURL(%q) = %q, expected %q", input, kind, expected)
+		}
+	}
+}
+
+func TestKindFromResponse(t *testing.T) {
+	tests := []struct {
+		contentType string
+		content     string
+		expected    string
+	}{
+		{"application/vnd.apple.mpegurl", "", kindHLS},
+		{"application/x-mpegURL; charset=utf-8", "", kindHLS},
+		{"application/dash+xml", "", kindDASH},
+		{"video/mp4", "", kindProgressive},
+		{"text/plain; charset=utf-8", "#EXTM3U\n#EXTINF:6,\na.ts\n", kindHLS},
+		{"application/octet-stream", `<?xml version="1.0"?><MPD type="static"></MPD>`, kindDASH},
+		{"text/html", "<html><body>#EXTM3U</body></html>", kindPage},
+	}
+
+	for _, test := range tests {
+		if kind := kindFromResponse(test.contentType, test.content); kind != test.expected {
+			t.Errorf("kindFromResponse(%q, %q) = %q, expected %q", test.contentType, test.content, kind, test.expected)
+		}
+	}
+}
+
+func TestExtractFromIframeDirectManifest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hls/index.m3u8", "/play":
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.0,\nseg0.ts\n#EXTINF:6.0,\nseg1.ts\n#EXT-X-ENDLIST\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// The first URL is recognised by its extension, the second one only by
+	// the Content-Type of the response.
+	for _, path := range []string{"/hls/index.m3u8", "/play"} {
+		streamInfo, err := New(models.DefaultConfig()).ExtractFromIframe(server.URL + path)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", path, err)
+		}
+		if streamInfo.Format != "hls" || streamInfo.ManifestURL != server.URL+path || len(streamInfo.Segments) != 2 {
+			t.Errorf("%s: expected the manifest to be parsed directly, got %+v", path, streamInfo)
+		}
+	}
+}
+
+func TestExtractFromIframeProgressive(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), progressiveChunkSize/5+3)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/files/My Movie.mp4":
+			http.ServeContent(w, r, "movie.mp4", time.Time{}, bytes.NewReader(data))
+		case "/norange":
+			w.Header().Set("Content-Type", "video/mp4")
+			w.Write(data[:100])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	streamInfo, err := New(models.DefaultConfig()).ExtractFromIframe(server.URL + "/files/My%20Movie.mp4")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if streamInfo.Format != "progressive" || streamInfo.Title != "My Movie" {
+		t.Errorf("Unexpected format %q or title %q", streamInfo.Format, streamInfo.Title)
+	}
+	if len(streamInfo.Segments) != 3 {
+		t.Fatalf("Expected 3 chunks, got %+v", streamInfo.Segments)
+	}
+
+	var total int64
+	for i, segment := range streamInfo.Segments {
+		if segment.ByteOffset != total || segment.Filename != fmt.Sprintf("chunk_%04d.mp4", i) {
+			t.Errorf("Unexpected chunk %d: %+v", i, segment)
+		}
+		total += segment.ByteLength
+	}
+	if total != int64(len(data)) {
+		t.Errorf("Expected the chunks to cover %d bytes, got %d", len(data), total)
+	}
+
+	streamInfo, err = New(models.DefaultConfig()).ExtractFromIframe(server.URL + "/norange")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(streamInfo.Segments) != 1 || streamInfo.Segments[0].ByteLength != 0 {
+		t.Errorf("Expected a single unranged segment, got %+v", streamInfo.Segments)
+	}
+}
+
+func TestSniffBody(t *testing.T) {
+	media := append([]byte{0, 0, 0, 0x20, 'f', 't', 'y', 'p'}, bytes.Repeat([]byte{0xff}, 4*sniffSize)...)
+	page := "<html><body>" + strings.Repeat("x", 2*sniffSize) + "</body></html>"
+
+	tests := []struct {
+		contentType string
+		body        string
+		kind        string
+		read        int
+	}{
+		{"video/mp4", string(media), kindProgressive, 0},
+		{"application/octet-stream", string(media), kindProgressive, sniffSize},
+		{"", string(media), kindProgressive, sniffSize},
+		{"text/html", page, kindPage, len(page)},
+		{"application/octet-stream", "#EXTM3U\n#EXTINF:6,\na.ts\n", kindHLS, 24},
+	}
+
+	for _, test := range tests {
+		reader := strings.NewReader(test.body)
+		body, kind, err := sniffBody(test.contentType, reader)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		read := len(test.body) - reader.Len()
+		if kind != test.kind || read != test.read || (kind != kindProgressive && string(body) != test.body) {
+			t.Errorf("sniffBody(%q) = kind %q after reading %d bytes, expected %q after %d", test.contentType, kind, read, test.kind, test.read)
+		}
+	}
+}
+
+func TestExtractFromIframeProgressiveWithoutExtension(t *testing.T) {
+	data := append([]byte{0, 0, 0, 0x20, 'f', 't', 'y', 'p'}, bytes.Repeat([]byte{0xff}, 2*sniffSize)...)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stream":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	streamInfo, err := New(models.DefaultConfig()).ExtractFromIframe(server.URL + "/stream")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if streamInfo.Format != "progressive" || len(streamInfo.Segments) == 0 {
+		t.Errorf("Expected the media file to be downloaded progressively, got %+v", streamInfo)
+	}
+}
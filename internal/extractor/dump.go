@@ -0,0 +1,323 @@
+package extractor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// dumpIndexFile is the file of a dump directory that lists the exchanges in
+// the order they happened. Response bodies are stored next to it.
+const dumpIndexFile = "index.json"
+
+// dumpIndex describes an extraction written with --dump-pages: the input it
+// started from, the chain of pages that led to the manifest and every HTTP
+// exchange on the way, redirects included.
+type dumpIndex struct {
+	Input   string `json:"input"`
+	BaseURL string `json:"base_url,omitempty"`
+	// File is the copy of a local or stdin input in the dump directory.
+	File    string         `json:"file,omitempty"`
+	Chain   []string       `json:"chain"`
+	Error   string         `json:"error,omitempty"`
+	Entries []dumpExchange `json:"entries"`
+}
+
+// dumpExchange is a request and the response it got. Body is the name of
+// the file holding the response body; of audio and video files, at most the
+// start that was read to recognise them is stored. Error is set instead of
+// the response when the request failed without one.
+type dumpExchange struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Range   string      `json:"range,omitempty"`
+	Referer string      `json:"referer,omitempty"`
+	Status  int         `json:"status,omitempty"`
+	Header  http.Header `json:"header,omitempty"`
+	Body    string      `json:"body,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// dumper is a RoundTripper that writes every exchange into a dump
+// directory. The index is rewritten after each one, so that an extraction
+// that fails or hangs still leaves everything fetched up to that point.
+type dumper struct {
+	dir  string
+	next http.RoundTripper
+
+	mu    sync.Mutex
+	index dumpIndex
+}
+
+func newDumper(dir string, next http.RoundTripper) *dumper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &dumper{dir: dir, next: next, index: dumpIndex{Chain: []string{}, Entries: []dumpExchange{}}}
+}
+
+func (d *dumper) RoundTrip(req *http.Request) (*http.Response, error) {
+	exchange := dumpExchange{
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Range:   req.Header.Get("Range"),
+		Referer: req.Header.Get("Referer"),
+	}
+
+	resp, err := d.next.RoundTrip(req)
+	if err != nil {
+		exchange.Error = err.Error()
+		return nil, d.record(exchange, nil, err)
+	}
+
+	exchange.Status = resp.StatusCode
+	exchange.Header = resp.Header.Clone()
+
+	// Media files can be gigabytes, and extraction never reads them. Of one
+	// that is only recognised from its body, the sniffed start is kept so
+	// that a replay recognises it again.
+	var body []byte
+	if resp.StatusCode == http.StatusPartialContent {
+		body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	} else {
+		var kind string
+		body, kind, err = sniffBody(resp.Header.Get("Content-Type"), resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if kind == kindProgressive {
+			resp.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		} else {
+			resp.Body.Close()
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+		}
+	}
+
+	if err := d.record(exchange, body, nil); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// record appends exchange to the index and stores body. It returns err, or
+// the error writing the dump if there was one.
+func (d *dumper) record(exchange dumpExchange, body []byte, err error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if writeErr := os.MkdirAll(d.dir, 0755); writeErr != nil {
+		return fmt.Errorf("failed to create dump directory: %w", writeErr)
+	}
+	if len(body) > 0 {
+		exchange.Body = fmt.Sprintf("%03d%s", len(d.index.Entries)+1, dumpExtension(exchange, body))
+		if writeErr := os.WriteFile(filepath.Join(d.dir, exchange.Body), body, 0644); writeErr != nil {
+			return fmt.Errorf("failed to write dump: %w", writeErr)
+		}
+	}
+	d.index.Entries = append(d.index.Entries, exchange)
+
+	if writeErr := d.save(); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+// start records the input of an extraction and returns the input to
+// extract from. Local files and standard input are copied into the dump
+// directory, which is where they are then read from.
+func (d *dumper) start(input, baseURL string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create dump directory: %w", err)
+	}
+	d.index.Input = input
+	d.index.BaseURL = baseURL
+
+	var content []byte
+	var err error
+	switch {
+	case input == "-":
+		content, err = io.ReadAll(os.Stdin)
+		d.index.File = "input.html"
+	case IsHARFile(input) || isLocalFile(input):
+		content, err = os.ReadFile(input)
+		d.index.File = "input" + strings.ToLower(filepath.Ext(input))
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read input: %w", err)
+	}
+
+	if d.index.File != "" {
+		input = filepath.Join(d.dir, d.index.File)
+		if err := os.WriteFile(input, content, 0644); err != nil {
+			return "", fmt.Errorf("failed to write dump: %w", err)
+		}
+	}
+	return input, d.save()
+}
+
+// finish records the outcome of an extraction.
+func (d *dumper) finish(streamInfo *models.StreamInfo, err error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if streamInfo != nil {
+		d.index.Chain = append([]string{}, streamInfo.Chain...)
+	}
+	if err != nil {
+		d.index.Error = err.Error()
+	}
+	return d.save()
+}
+
+func (d *dumper) save() error {
+	data, err := json.MarshalIndent(d.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(d.dir, dumpIndexFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write dump: %w", err)
+	}
+	return nil
+}
+
+// dumpExtension picks a file extension for a response body, so that the
+// dumped files open in a suitable editor.
+func dumpExtension(exchange dumpExchange, body []byte) string {
+	switch kindFromResponse(exchange.Header.Get("Content-Type"), string(body)) {
+	case kindHLS:
+		return ".m3u8"
+	case kindDASH:
+		return ".mpd"
+	}
+
+	if strings.Contains(exchange.Header.Get("Content-Type"), "html") {
+		return ".html"
+	}
+	if u, err := url.Parse(exchange.URL); err == nil {
+		if ext := path.Ext(u.Path); len(ext) > 1 && len(ext) <= 5 {
+			return ext
+		}
+	}
+	return ".txt"
+}
+
+// replayer is a RoundTripper that answers requests with the exchanges of a
+// dump directory instead of going to the network. Exchanges are matched on
+// method, URL and Range and used in the order they were recorded; once they
+// are used up, the last one is repeated.
+type replayer struct {
+	dir     string
+	entries []dumpExchange
+
+	mu   sync.Mutex
+	used []bool
+}
+
+func (r *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	exchange, ok := r.next(req)
+	if !ok {
+		return nil, fmt.Errorf("no recorded response for %s %s", req.Method, req.URL)
+	}
+	if exchange.Error != "" {
+		return nil, fmt.Errorf("%s (recorded)", exchange.Error)
+	}
+
+	var body []byte
+	if exchange.Body != "" {
+		var err error
+		body, err = os.ReadFile(filepath.Join(r.dir, exchange.Body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dump: %w", err)
+		}
+	}
+
+	header := exchange.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Status, http.StatusText(exchange.Status)),
+		StatusCode:    exchange.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (r *replayer) next(req *http.Request) (dumpExchange, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last := -1
+	for i, exchange := range r.entries {
+		if exchange.Method != req.Method || exchange.URL != req.URL.String() || exchange.Range != req.Header.Get("Range") {
+			continue
+		}
+		if !r.used[i] {
+			r.used[i] = true
+			return exchange, true
+		}
+		last = i
+	}
+
+	if last < 0 {
+		return dumpExchange{}, false
+	}
+	return r.entries[last], true
+}
+
+// Replay extracts stream information from a directory written with
+// --dump-pages without going to the network: the recorded input is
+// extracted again, and every request is answered with the response that was
+// recorded for it. Requests that weren't recorded fail, so a replay always
+// sees exactly the pages the original extraction saw.
+func (e *Extractor) Replay(dir string) (*models.StreamInfo, error) {
+	data, err := os.ReadFile(filepath.Join(dir, dumpIndexFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dump: %w", err)
+	}
+	var index dumpIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse dump index: %w", err)
+	}
+
+	input := index.Input
+	if index.File != "" {
+		input = filepath.Join(dir, index.File)
+	}
+
+	client := *e.client
+	client.Transport = &replayer{dir: dir, entries: index.Entries, used: make([]bool, len(index.Entries))}
+	replay := *e
+	replay.client = &client
+	replay.dump = nil
+
+	return replay.extract(input, index.BaseURL)
+}
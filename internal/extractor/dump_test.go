@@ -0,0 +1,106 @@
+package extractor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+func TestDumpAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/embed":
+			http.Redirect(w, r, "/player", http.StatusFound)
+		case "/player":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<title>Dumped</title><script>var src = "/hls/index.m3u8";</script>`))
+		case "/hls/index.m3u8":
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Write([]byte("#EXTM3U\n#EXTINF:6.0,\nseg0.ts\n#EXTINF:6.0,\nseg1.ts\n#EXT-X-ENDLIST\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	dir := filepath.Join(t.TempDir(), "dump")
+	config := models.DefaultConfig()
+	config.DumpDir = dir
+	if _, err := New(config).Extract(server.URL + "/embed"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, dumpIndexFile))
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	var index dumpIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatalf("Failed to parse index: %v", err)
+	}
+	if len(index.Entries) != 3 || index.Entries[0].Status != http.StatusFound || index.Entries[2].Body != "003.m3u8" {
+		t.Errorf("Expected the redirect, page and manifest in the index, got %+v", index.Entries)
+	}
+	if len(index.Chain) != 1 || index.Chain[0] != server.URL+"/player" {
+		t.Errorf("Expected the chain of pages, got %v", index.Chain)
+	}
+
+	// The server is gone, so everything comes from the dump.
+	streamInfo, err := New(models.DefaultConfig()).Replay(dir)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if streamInfo.ManifestURL != server.URL+"/hls/index.m3u8" || len(streamInfo.Segments) != 2 || streamInfo.Title != "Dumped" {
+		t.Errorf("Unexpected replay result: %+v", streamInfo)
+	}
+}
+
+func TestDumpLocalInput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("#EXTM3U\n#EXTINF:6.0,\nseg0.ts\n#EXT-X-ENDLIST\n"))
+	}))
+
+	page := filepath.Join(t.TempDir(), "page.html")
+	if err := os.WriteFile(page, []byte(`<source src="/hls/index.m3u8">`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "dump")
+	config := models.DefaultConfig()
+	config.DumpDir = dir
+	config.BaseURL = server.URL + "/embed"
+	if _, err := New(config).Extract(page); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, "input.html")); err != nil {
+		t.Errorf("Expected the page to be copied into the dump: %v", err)
+	}
+
+	// The base URL comes from the dump rather than the configuration.
+	streamInfo, err := New(models.DefaultConfig()).Replay(dir)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if streamInfo.ManifestURL != server.URL+"/hls/index.m3u8" {
+		t.Errorf("Unexpected manifest %s", streamInfo.ManifestURL)
+	}
+}
+
+func TestReplayUnrecordedRequest(t *testing.T) {
+	dir := t.TempDir()
+	index := `{"input": "https://example.com/embed", "chain": [], "entries": []}`
+	if err := os.WriteFile(filepath.Join(dir, dumpIndexFile), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(models.DefaultConfig()).Replay(dir); err == nil {
+		t.Error("Expected an error for a request missing from the dump")
+	}
+}
@@ -2,123 +2,529 @@ package extractor
 
 import (
 	"fmt"
+	"html"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/yebrai/stream-snatchet/internal/session"
 	"github.com/yebrai/stream-snatchet/pkg/models"
 )
 
 type Extractor struct {
-	client *http.Client
-	config *models.Config
+	client  *http.Client
+	config  *models.Config
+	session *session.Session
+	dump    *dumper
 }
 
 func New(config *models.Config) *Extractor {
-	return &Extractor{
-		client: &http.Client{
-			Timeout: time.Duration(config.TimeoutSeconds) * time.Second,
-		},
-		config: config,
+	return NewWithSession(config, session.New(config))
+}
+
+// NewWithSession creates an extractor that shares cookies and learned
+// headers with other components using the same session.
+// With a configured dump directory, every exchange of the extractor is
+// written into it; see Replay.
+func NewWithSession(config *models.Config, sess *session.Session) *Extractor {
+	e := &Extractor{
+		client:  sess.Client(),
+		config:  config,
+		session: sess,
+	}
+
+	if config.DumpDir != "" {
+		client := *e.client
+		e.dump = newDumper(config.DumpDir, client.Transport)
+		client.Transport = e.dump
+		e.client = &client
+	}
+	return e
+}
+
+// Extract extracts stream information from input: the path of a HAR file
+// for ExtractFromHAR, the path of a saved HTML page or "-" for one on
+// standard input, both for ExtractFromHTML with the configured base URL, or
+// otherwise a URL for ExtractFromIframe.
+func (e *Extractor) Extract(input string) (*models.StreamInfo, error) {
+	if e.dump == nil {
+		return e.extract(input, e.config.BaseURL)
+	}
+
+	input, err := e.dump.start(input, e.config.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	streamInfo, err := e.extract(input, e.config.BaseURL)
+	if dumpErr := e.dump.finish(streamInfo, err); err == nil && dumpErr != nil {
+		return nil, dumpErr
+	}
+	return streamInfo, err
+}
+
+func (e *Extractor) extract(input, baseURL string) (*models.StreamInfo, error) {
+	switch {
+	case input == "-":
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page from stdin: %w", err)
+		}
+		return e.ExtractFromHTML(string(content), baseURL)
+	case IsHARFile(input):
+		return e.ExtractFromHAR(input)
+	case isLocalFile(input):
+		content, err := os.ReadFile(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page: %w", err)
+		}
+		return e.ExtractFromHTML(string(content), baseURL)
+	default:
+		return e.ExtractFromIframe(input)
 	}
 }
 
+// ExtractFromIframe extracts stream information from an iframe page. Site
+// extractors registered for the page's URL are tried first; the generic
+// manifest discovery is used when none matches or all of them fail. URLs
+// that point straight at a manifest or a media file are used as they are.
 func (e *Extractor) ExtractFromIframe(iframeURL string) (*models.StreamInfo, error) {
+	var streamInfo *models.StreamInfo
+	var err error
+
+	if kind := kindFromURL(iframeURL); kind != kindPage {
+		streamInfo, err = e.extractDirect(iframeURL, kind)
+	} else {
+		streamInfo, err = e.extractFromSites(iframeURL)
+		if err != nil {
+			streamInfo, err = e.extractGeneric(iframeURL)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e.finishExtraction(streamInfo)
+	return streamInfo, nil
+}
+
+// finishExtraction loads the selected subtitle tracks and applies the
+// configured title once a manifest has been loaded.
+func (e *Extractor) finishExtraction(streamInfo *models.StreamInfo) {
+	if e.wantSubtitles() {
+		e.loadSubtitles(streamInfo)
+	}
+
+	if e.config.Title != "" {
+		streamInfo.Title = e.config.Title
+	}
+}
+
+func (e *Extractor) extractFromSites(iframeURL string) (*models.StreamInfo, error) {
+	sites := matchingSiteExtractors(iframeURL)
+	if len(sites) == 0 {
+		return nil, fmt.Errorf("no site extractor matches %s", iframeURL)
+	}
+
+	var lastErr error
+	for _, site := range sites {
+		streamInfo, err := e.extractWithSite(site, iframeURL)
+		if err == nil {
+			return streamInfo, nil
+		}
+
+		lastErr = fmt.Errorf("site extractor %s: %w", site.Name(), err)
+		if e.config.Verbose {
+			fmt.Printf("Site extractor %s failed, falling back: %v\n", site.Name(), err)
+		}
+	}
+
+	return nil, lastErr
+}
+
+func (e *Extractor) extractGeneric(iframeURL string) (*models.StreamInfo, error) {
+	result, err := e.crawl(iframeURL)
+	if err != nil {
+		return nil, err
+	}
+	return e.loadCrawlResult(iframeURL, result)
+}
+
+// loadCrawlResult loads the manifest or media file a crawl found.
+func (e *Extractor) loadCrawlResult(iframeURL string, result *crawlResult) (*models.StreamInfo, error) {
 	streamInfo := &models.StreamInfo{
 		IframeURL: iframeURL,
 		Headers:   make(map[string]string),
 	}
 
-	iframeContent, err := e.fetchContent(iframeURL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch iframe content: %w", err)
+	streamInfo.ManifestURL = result.manifestURL
+	streamInfo.Chain = result.chain
+	streamInfo.Title = result.title
+	streamInfo.Subtitles = result.subtitles
+
+	e.session.SetReferer(result.pageURL)
+
+	if len(result.candidates) > 1 || (len(result.candidates) > 0 && e.config.SourceIndex > 0) {
+		candidates, index, err := e.chooseCandidate(result.candidates)
+		if err != nil {
+			return nil, err
+		}
+		streamInfo.Candidates = candidates
+		streamInfo.ManifestURL = candidates[index].URL
+		result.progressive = candidates[index].Format == kindProgressive
 	}
 
-	manifestURL, err := e.findManifestURL(iframeContent, iframeURL)
+	var err error
+	if result.progressive {
+		err = e.loadProgressive(streamInfo, streamInfo.ManifestURL)
+	} else {
+		err = e.loadManifest(streamInfo)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to find manifest URL: %w", err)
+		return nil, err
+	}
+	streamInfo.Headers = e.session.Headers()
+
+	if streamInfo.Title == "" {
+		streamInfo.Title = result.fallbackTitle
 	}
 
-	streamInfo.ManifestURL = manifestURL
-	streamInfo.BaseURL = e.getBaseURL(manifestURL)
+	return streamInfo, nil
+}
+
+func (e *Extractor) loadManifest(streamInfo *models.StreamInfo) error {
+	var referer string
+	if len(streamInfo.Chain) > 0 {
+		referer = streamInfo.Chain[len(streamInfo.Chain)-1]
+	}
 
-	manifestContent, err := e.fetchContent(manifestURL)
+	manifestContent, manifestURL, err := e.fetchContent(streamInfo.ManifestURL, referer)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch manifest: %w", err)
+		return fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+	streamInfo.PlaylistURL = manifestURL
+
+	if isDASHManifest(manifestContent) {
+		streamInfo.BaseURL = e.getBaseURL(manifestURL)
+		if err := e.parseDASHManifest(manifestContent, manifestURL, streamInfo); err != nil {
+			return fmt.Errorf("failed to parse DASH manifest: %w", err)
+		}
+		return nil
+	}
+
+	streamInfo.Format = "hls"
+
+	if streamInfo.Title == "" {
+		streamInfo.Title = sessionDataTitle(manifestContent)
+	}
+
+	if isMasterPlaylist(manifestContent) {
+		variants, err := e.parseMasterPlaylist(manifestContent, manifestURL)
+		if err != nil {
+			return fmt.Errorf("failed to parse master playlist: %w", err)
+		}
+
+		variant, err := selectVariant(variants, e.config.Quality)
+		if err != nil {
+			return fmt.Errorf("failed to select variant: %w", err)
+		}
+
+		streamInfo.Variants = variants
+		streamInfo.Quality = variant.Label()
+		streamInfo.AudioTracks = parseAudioRenditions(manifestContent, manifestURL)
+
+		if audio := selectAudioTrack(streamInfo.AudioTracks, variant.Audio, e.config.AudioLang); audio != nil && audio.URL != "" {
+			if err := e.loadAudioPlaylist(audio, referer); err != nil {
+				return fmt.Errorf("failed to load audio rendition: %w", err)
+			}
+			streamInfo.Audio = audio
+		}
+
+		for _, track := range parseSubtitleRenditions(manifestContent, manifestURL) {
+			if variant.Subtitles == "" || track.GroupID == variant.Subtitles {
+				streamInfo.Subtitles = append(streamInfo.Subtitles, track)
+			}
+		}
+
+		manifestContent, streamInfo.PlaylistURL, err = e.fetchContent(variant.URL, referer)
+		if err != nil {
+			return fmt.Errorf("failed to fetch variant playlist: %w", err)
+		}
 	}
 
+	streamInfo.BaseURL = e.getBaseURL(streamInfo.PlaylistURL)
+
 	if err := e.parseManifest(manifestContent, streamInfo); err != nil {
-		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+		return fmt.Errorf("failed to parse manifest: %w", err)
 	}
 
-	return streamInfo, nil
+	return nil
 }
 
-func (e *Extractor) fetchContent(url string) (string, error) {
-	req, err := http.NewRequest("GET", url, nil)
+// loadAudioPlaylist fetches the media playlist of an alternate audio
+// rendition. Its files are prefixed with "audio_" so they don't collide with
+// the video segments in the download directory.
+func (e *Extractor) loadAudioPlaylist(audio *models.Track, referer string) error {
+	content, playlistURL, err := e.fetchContent(audio.URL, referer)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	req.Header.Set("User-Agent", e.config.UserAgent)
-	req.Header.Set("Accept", "*/*")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
-	req.Header.Set("Referer", url)
+	rendition := &models.StreamInfo{BaseURL: e.getBaseURL(playlistURL)}
+	if err := e.parseManifest(content, rendition); err != nil {
+		return err
+	}
+
+	for i := range rendition.Segments {
+		rendition.Segments[i].Filename = "audio_" + rendition.Segments[i].Filename
+		if rendition.Segments[i].InitFilename != "" {
+			rendition.Segments[i].InitFilename = "audio_" + rendition.Segments[i].InitFilename
+		}
+	}
+	for i := range rendition.InitSegments {
+		rendition.InitSegments[i].Filename = "audio_" + rendition.InitSegments[i].Filename
+	}
+
+	audio.Segments = rendition.Segments
+	audio.InitSegments = rendition.InitSegments
+	return nil
+}
+
+// RefreshPlaylist reloads the media playlist of a live stream and returns its
+// current window. Segments keep the media sequence numbers of the playlist so
+// callers can tell which of them are new.
+func (e *Extractor) RefreshPlaylist(streamInfo *models.StreamInfo) (*models.StreamInfo, error) {
+	var referer string
+	if len(streamInfo.Chain) > 0 {
+		referer = streamInfo.Chain[len(streamInfo.Chain)-1]
+	}
+
+	content, playlistURL, err := e.fetchContent(streamInfo.PlaylistURL, referer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reload playlist: %w", err)
+	}
+
+	refreshed := &models.StreamInfo{
+		IframeURL:   streamInfo.IframeURL,
+		Chain:       streamInfo.Chain,
+		ManifestURL: streamInfo.ManifestURL,
+		PlaylistURL: playlistURL,
+		BaseURL:     e.getBaseURL(playlistURL),
+		Format:      streamInfo.Format,
+		Headers:     streamInfo.Headers,
+	}
+	if err := e.parseManifest(content, refreshed); err != nil {
+		return nil, fmt.Errorf("failed to parse playlist: %w", err)
+	}
+
+	if streamInfo.Audio != nil {
+		audio := *streamInfo.Audio
+		if err := e.loadAudioPlaylist(&audio, referer); err != nil {
+			return nil, fmt.Errorf("failed to reload audio playlist: %w", err)
+		}
+		refreshed.Audio = &audio
+	}
+
+	return refreshed, nil
+}
+
+// fetchContent returns the response body together with the final URL after
+// redirects, which relative references in the body must be resolved against.
+// An empty referer sends the session Referer, or the requested URL itself
+// when the session has none.
+func (e *Extractor) fetchContent(url, referer string) (string, string, error) {
+	content, finalURL, _, err := e.fetchPage(url, referer)
+	return content, finalURL, err
+}
+
+// fetchPage is like fetchContent but also returns the kind of the response,
+// from its Content-Type or its body. Only the start of media files is read,
+// and no content is returned for them, since the URL points to a media file
+// rather than a document.
+func (e *Extractor) fetchPage(url, referer string) (string, string, string, error) {
+	req, err := e.newRequest(url, referer)
+	if err != nil {
+		return "", "", "", err
+	}
 
 	resp, err := e.client.Do(req)
 	if err != nil {
-		return "", err
+		return "", "", "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP error: %d %s", resp.StatusCode, resp.Status)
+		return "", "", "", fmt.Errorf("HTTP error: %d %s", resp.StatusCode, resp.Status)
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	body, kind, err := sniffBody(resp.Header.Get("Content-Type"), resp.Body)
 	if err != nil {
-		return "", err
+		return "", "", "", err
+	}
+	if kind == kindProgressive {
+		return "", resp.Request.URL.String(), kind, nil
+	}
+
+	return string(body), resp.Request.URL.String(), kind, nil
+}
+
+// newRequest creates a session request for url with the Referer fallbacks
+// described at fetchContent.
+func (e *Extractor) newRequest(url, referer string) (*http.Request, error) {
+	if referer == "" {
+		referer = e.session.Header("Referer")
+	}
+	if referer == "" {
+		referer = url
+	}
+
+	req, err := e.session.NewRequestWithReferer(url, referer)
+	if err != nil {
+		return nil, err
 	}
 
-	return string(body), nil
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	return req, nil
 }
 
 func (e *Extractor) findManifestURL(content, baseURL string) (string, error) {
+	discovery, err := discoverManifest(content, baseURL)
+	if err != nil {
+		return "", err
+	}
+	return discovery.manifestURL, nil
+}
+
+// pageDiscovery is what a single page reveals about its stream.
+type pageDiscovery struct {
+	manifestURL string
+	subtitles   []models.Track
+	candidates  []models.Candidate
+}
+
+// discoverManifest looks for the main manifest of a page. Structured player
+// configurations are preferred over pattern matching since the first
+// manifest-looking string is often a preview or an ad. Both are tried on the
+// page itself and on any packed or encoded scripts it contains. Every
+// manifest found is kept as a candidate, in that order of preference.
+func discoverManifest(content, baseURL string) (*pageDiscovery, error) {
+	fragments := append([]string{content}, deobfuscate(content)...)
+
+	discovery := &pageDiscovery{}
+	seen := make(map[string]bool)
+	add := func(manifestURL, source string) {
+		if !seen[manifestURL] {
+			seen[manifestURL] = true
+			discovery.candidates = append(discovery.candidates, models.Candidate{
+				URL:    manifestURL,
+				Source: source,
+				Page:   baseURL,
+			})
+		}
+	}
+
+	var sources []sourceCandidate
+	for _, fragment := range fragments {
+		config := parsePlayerConfig(fragment, baseURL)
+		sources = append(sources, config.sources...)
+		discovery.subtitles = append(discovery.subtitles, config.subtitles...)
+	}
+	for _, source := range manifestSources(sources) {
+		add(source.URL, source.origin())
+	}
+
+	for i, fragment := range fragments {
+		origin := "pattern"
+		if i > 0 {
+			origin = "decoded script"
+		}
+
+		for _, manifestURL := range matchManifestURLs(fragment, baseURL) {
+			add(manifestURL, origin)
+		}
+		// Decoded string literals are usually a bare URL without quotes.
+		if i > 0 {
+			for _, manifestURL := range matchManifestURLs(`"`+strings.TrimSpace(fragment)+`"`, baseURL) {
+				add(manifestURL, origin)
+			}
+		}
+	}
+
+	if len(discovery.candidates) == 0 {
+		return nil, fmt.Errorf("no manifest URL found in iframe content")
+	}
+
+	discovery.manifestURL = discovery.candidates[0].URL
+	return discovery, nil
+}
+
+// matchManifestURLs returns the manifest URLs the patterns find in content,
+// resolved against baseURL, ordered by pattern and then by position.
+func matchManifestURLs(content, baseURL string) []string {
+	content = unescapeContent(content)
+
 	patterns := []string{
-		`['"](https?://[^'"]*\.m3u8[^'"]*?)['"]`,
-		`['"](https?://[^'"]*m3u8[^'"]*?)['"]`,
-		`source:\s*['"](https?://[^'"]*\.m3u8[^'"]*?)['"]`,
-		`src:\s*['"](https?://[^'"]*\.m3u8[^'"]*?)['"]`,
-		`file:\s*['"](https?://[^'"]*\.m3u8[^'"]*?)['"]`,
-		`url:\s*['"](https?://[^'"]*\.m3u8[^'"]*?)['"]`,
+		`['"]((?:https?:)?//[^'"\s]*\.m3u8[^'"\s]*?)['"]`,
+		`['"]((?:https?:)?//[^'"\s]*m3u8[^'"\s]*?)['"]`,
+		`['"]((?:https?:)?//[^'"\s]*\.mpd[^'"\s]*?)['"]`,
+		`source:\s*['"]([^'"\s]+\.m3u8[^'"\s]*?)['"]`,
+		`src:\s*['"]([^'"\s]+\.m3u8[^'"\s]*?)['"]`,
+		`file:\s*['"]([^'"\s]+\.m3u8[^'"\s]*?)['"]`,
+		`url:\s*['"]([^'"\s]+\.m3u8[^'"\s]*?)['"]`,
+		`['"]([^'"\s<>()]+\.(?:m3u8|mpd)(?:\?[^'"\s<>]*)?)['"]`,
+	}
+
+	baseURLParsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil
 	}
 
+	var manifestURLs []string
 	for _, pattern := range patterns {
 		re := regexp.MustCompile(pattern)
-		matches := re.FindStringSubmatch(content)
-		if len(matches) > 1 {
+		for _, matches := range re.FindAllStringSubmatch(content, -1) {
 			manifestURL := matches[1]
-			if strings.HasPrefix(manifestURL, "http") {
-				return manifestURL, nil
+			if encodedURLPattern.MatchString(manifestURL) {
+				// URL-encoded absolute URL; decoded by deobfuscate instead.
+				continue
 			}
-
-			baseURLParsed, err := url.Parse(baseURL)
-			if err != nil {
+			if strings.HasPrefix(manifestURL, "http") {
+				manifestURLs = append(manifestURLs, manifestURL)
 				continue
 			}
+
 			manifestURLParsed, err := url.Parse(manifestURL)
 			if err != nil {
 				continue
 			}
-			return baseURLParsed.ResolveReference(manifestURLParsed).String(), nil
+			manifestURLs = append(manifestURLs, baseURLParsed.ResolveReference(manifestURLParsed).String())
 		}
 	}
 
-	return "", fmt.Errorf("no manifest URL found in iframe content")
+	return manifestURLs
+}
+
+var encodedURLPattern = regexp.MustCompile(`(?i)^https?%3A`)
+
+var jsEscapePattern = regexp.MustCompile(`\\(?:u00([0-9a-fA-F]{2})|x([0-9a-fA-F]{2}))`)
+
+// unescapeContent undoes the HTML entity and JavaScript string escaping that
+// players commonly apply to URLs, such as &amp;, &#x2F;, \/ and \u002F, so that
+// the manifest patterns can match them.
+func unescapeContent(content string) string {
+	content = html.UnescapeString(content)
+	content = jsEscapePattern.ReplaceAllStringFunc(content, func(match string) string {
+		value, err := strconv.ParseUint(match[len(match)-2:], 16, 8)
+		if err != nil || value < 0x20 || value == '"' || value == '\'' || value == '\\' {
+			return match
+		}
+		return string(rune(value))
+	})
+	return strings.ReplaceAll(content, `\/`, "/")
 }
 
 func (e *Extractor) getBaseURL(manifestURL string) string {
@@ -132,16 +538,123 @@ func (e *Extractor) getBaseURL(manifestURL string) string {
 	return u.String()
 }
 
+// parseProgramDateTime parses an EXT-X-PROGRAM-DATE-TIME value, which is
+// ISO 8601 but sometimes written without the colon in the zone offset.
+func parseProgramDateTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		t, err = time.Parse("2006-01-02T15:04:05.999999999Z0700", value)
+	}
+	return t, err
+}
+
 func (e *Extractor) parseManifest(content string, streamInfo *models.StreamInfo) error {
+	if isMasterPlaylist(content) {
+		return fmt.Errorf("expected media playlist, got master playlist")
+	}
+
 	lines := strings.Split(content, "\n")
 	var segments []models.Segment
+	var initSegments []models.Segment
 	var currentDuration float64
+	var currentKey *models.Key
+	var currentInit string
+	var pendingRange string
+	var rangeURL string
+	var rangeEnd int64
+	var targetDuration float64
+	var playlistType string
+	endList := false
 	segmentIndex := 0
+	mediaSequence := 0
+	discontinuity := 0
+	inCueOut := false
+	inDateRange := false
+	var dateRangeRemaining float64
+	var programDateTime time.Time
 
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 
-		if strings.HasPrefix(line, "#EXTINF:") {
+		if strings.HasPrefix(line, "#EXT-X-TARGETDURATION:") {
+			duration, err := strconv.ParseFloat(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"), 64)
+			if err == nil {
+				targetDuration = duration
+			}
+		} else if strings.HasPrefix(line, "#EXT-X-PLAYLIST-TYPE:") {
+			playlistType = strings.TrimPrefix(line, "#EXT-X-PLAYLIST-TYPE:")
+		} else if line == "#EXT-X-ENDLIST" {
+			endList = true
+		} else if strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:") {
+			sequence, err := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+			if err == nil {
+				mediaSequence = sequence
+			}
+		} else if strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:") {
+			if t, err := parseProgramDateTime(strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:")); err == nil {
+				programDateTime = t
+			}
+		} else if strings.HasPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:") {
+			sequence, err := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"))
+			if err == nil {
+				discontinuity = sequence
+			}
+		} else if line == "#EXT-X-DISCONTINUITY" {
+			discontinuity++
+		} else if strings.HasPrefix(line, "#EXT-X-CUE-OUT") {
+			// Also matches #EXT-X-CUE-OUT-CONT, which repeats the marker
+			// in live windows that start in the middle of a break.
+			inCueOut = true
+		} else if strings.HasPrefix(line, "#EXT-X-CUE-IN") {
+			inCueOut = false
+		} else if strings.HasPrefix(line, "#EXT-X-DATERANGE:") {
+			// SCTE-35 date ranges are taken to start at the next segment
+			// and to last for their duration, or until the matching
+			// SCTE35-IN when they have none.
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-DATERANGE:"))
+			if attrs["SCTE35-OUT"] != "" {
+				inDateRange = true
+				dateRangeRemaining, _ = strconv.ParseFloat(attrs["DURATION"], 64)
+				if dateRangeRemaining == 0 {
+					dateRangeRemaining, _ = strconv.ParseFloat(attrs["PLANNED-DURATION"], 64)
+				}
+			} else if attrs["SCTE35-IN"] != "" {
+				inDateRange = false
+			}
+		} else if strings.HasPrefix(line, "#EXT-X-KEY:") {
+			key, err := parseKey(strings.TrimPrefix(line, "#EXT-X-KEY:"), streamInfo.BaseURL)
+			if err != nil {
+				return err
+			}
+			currentKey = key
+		} else if strings.HasPrefix(line, "#EXT-X-MAP:") {
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-MAP:"))
+			if attrs["URI"] == "" {
+				return fmt.Errorf("EXT-X-MAP without URI")
+			}
+
+			initURL := resolveURL(streamInfo.BaseURL, attrs["URI"])
+			initSegment := models.Segment{
+				URL:      initURL,
+				Index:    len(initSegments),
+				Sequence: mediaSequence + segmentIndex,
+				Filename: fmt.Sprintf("init_%02d%s", len(initSegments), segmentExtension(initURL, ".mp4")),
+				Key:      currentKey,
+			}
+			if attrs["BYTERANGE"] != "" {
+				offset, length, err := parseByteRange(attrs["BYTERANGE"], 0)
+				if err != nil {
+					return err
+				}
+				initSegment.ByteOffset = offset
+				initSegment.ByteLength = length
+			}
+			initSegments = append(initSegments, initSegment)
+			currentInit = initSegment.Filename
+		} else if strings.HasPrefix(line, "#EXT-X-BYTERANGE:") {
+			pendingRange = strings.TrimPrefix(line, "#EXT-X-BYTERANGE:")
+		} else if strings.HasPrefix(line, "#EXTINF:") {
 			durationStr := strings.TrimPrefix(line, "#EXTINF:")
 			durationStr = strings.Split(durationStr, ",")[0]
 			duration, err := strconv.ParseFloat(durationStr, 64)
@@ -153,29 +666,80 @@ func (e *Extractor) parseManifest(content string, streamInfo *models.StreamInfo)
 		} else if line != "" && !strings.HasPrefix(line, "#") {
 			segmentURL := line
 			if !strings.HasPrefix(segmentURL, "http") {
-				segmentURL = streamInfo.BaseURL + segmentURL
+				segmentURL = resolveURL(streamInfo.BaseURL, segmentURL)
+			}
+
+			defaultExtension := ".ts"
+			if currentInit != "" {
+				defaultExtension = ".m4s"
 			}
 
 			segment := models.Segment{
-				URL:      segmentURL,
-				Index:    segmentIndex,
-				Duration: currentDuration,
-				Filename: fmt.Sprintf("segment_%04d.ts", segmentIndex),
+				URL:           segmentURL,
+				Index:         segmentIndex,
+				Sequence:      mediaSequence + segmentIndex,
+				Duration:      currentDuration,
+				Filename:      fmt.Sprintf("segment_%04d%s", segmentIndex, segmentExtension(segmentURL, defaultExtension)),
+				Key:           currentKey,
+				InitFilename:  currentInit,
+				Discontinuity: discontinuity,
+				Ad:            inCueOut || inDateRange,
+			}
+
+			// The date of a segment without its own tag follows from the
+			// previous one.
+			if !programDateTime.IsZero() {
+				segment.ProgramDateTime = programDateTime
+				programDateTime = programDateTime.Add(time.Duration(currentDuration * float64(time.Second)))
 			}
+
+			if inDateRange && dateRangeRemaining > 0 {
+				dateRangeRemaining -= currentDuration
+				// Allow for EXTINF rounding.
+				if dateRangeRemaining < 0.1 {
+					inDateRange = false
+				}
+			}
+
+			if pendingRange != "" {
+				nextOffset := int64(0)
+				if rangeURL == segmentURL {
+					nextOffset = rangeEnd
+				}
+
+				offset, length, err := parseByteRange(pendingRange, nextOffset)
+				if err != nil {
+					return err
+				}
+				segment.ByteOffset = offset
+				segment.ByteLength = length
+				rangeURL = segmentURL
+				rangeEnd = offset + length
+				pendingRange = ""
+			}
+
 			segments = append(segments, segment)
 			segmentIndex++
 		}
 	}
 
+	if e.config.SkipAds {
+		segments, initSegments, streamInfo.AdBreaks = stripAds(segments, initSegments)
+	}
+
 	if len(segments) == 0 {
 		return fmt.Errorf("no segments found in manifest")
 	}
 
 	streamInfo.Segments = segments
+	streamInfo.InitSegments = initSegments
+	streamInfo.TargetDuration = time.Duration(targetDuration * float64(time.Second))
+	streamInfo.IsLive = !endList && playlistType != "VOD"
 
 	var totalDuration float64
-	for _, segment := range segments {
-		totalDuration += segment.Duration
+	for i := range segments {
+		segments[i].Offset = totalDuration
+		totalDuration += segments[i].Duration
 	}
 	streamInfo.Duration = time.Duration(totalDuration) * time.Second
 
@@ -1,8 +1,21 @@
 package extractor
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/yebrai/stream-snatchet/internal/info"
 	"github.com/yebrai/stream-snatchet/pkg/models"
 )
 
@@ -61,20 +74,65 @@ func TestFindManifestURL(t *testing.T) {
 		name        string
 		content     string
 		baseURL     string
+		expected    string
 		expectError bool
 	}{
 		{
 			name:        "Valid manifest URL in quotes",
 			content:     `<script>var videoUrl = "https://example.com/video.m3u8";</script>`,
 			baseURL:     "https://example.com",
+			expected:    "https://example.com/video.m3u8",
 			expectError: false,
 		},
 		{
 			name:        "Valid manifest URL with source property",
 			content:     `source: "https://example.com/playlist.m3u8"`,
 			baseURL:     "https://example.com",
+			expected:    "https://example.com/playlist.m3u8",
 			expectError: false,
 		},
+		{
+			name:     "Root-relative manifest URL",
+			content:  `player.setup({src: "/hls/master.m3u8"});`,
+			baseURL:  "https://player.example.com/embed/123",
+			expected: "https://player.example.com/hls/master.m3u8",
+		},
+		{
+			name:     "Path-relative manifest URL",
+			content:  `<video><source src="streams/index.m3u8?token=a1" type="application/x-mpegURL"></video>`,
+			baseURL:  "https://player.example.com/embed/123",
+			expected: "https://player.example.com/embed/streams/index.m3u8?token=a1",
+		},
+		{
+			name:     "Protocol-relative manifest URL",
+			content:  `var hls = '//cdn.x/a.m3u8';`,
+			baseURL:  "https://player.example.com/embed/123",
+			expected: "https://cdn.x/a.m3u8",
+		},
+		{
+			name:     "JS-escaped slashes",
+			content:  `{"file":"https:\/\/cdn.example.com\/v\/master.m3u8"}`,
+			baseURL:  "https://player.example.com/",
+			expected: "https://cdn.example.com/v/master.m3u8",
+		},
+		{
+			name:     "JS unicode and hex escapes",
+			content:  `var u = "\u002F\u002Fcdn.example.com\x2Fhls\x2Fmaster.m3u8";`,
+			baseURL:  "http://player.example.com/",
+			expected: "http://cdn.example.com/hls/master.m3u8",
+		},
+		{
+			name:     "HTML entities in attribute",
+			content:  `<div data-config="{&quot;src&quot;:&quot;&#x2F;live&#x2F;master.m3u8?a=1&amp;b=2&quot;}"></div>`,
+			baseURL:  "https://player.example.com/embed",
+			expected: "https://player.example.com/live/master.m3u8?a=1&b=2",
+		},
+		{
+			name:        "Bare extension is not a URL",
+			content:     `<script>if (src.endsWith(".m3u8") || type === '.mpd') { hls.loadSource(src); }</script>`,
+			baseURL:     "https://example.com/embed/1",
+			expectError: true,
+		},
 		{
 			name:        "No manifest URL found",
 			content:     `<div>Some random content without manifest URL</div>`,
@@ -85,7 +143,7 @@ func TestFindManifestURL(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			_, err := ext.findManifestURL(test.content, test.baseURL)
+			result, err := ext.findManifestURL(test.content, test.baseURL)
 
 			if test.expectError && err == nil {
 				t.Error("Expected error but got none")
@@ -94,10 +152,43 @@ func TestFindManifestURL(t *testing.T) {
 			if !test.expectError && err != nil {
 				t.Errorf("Unexpected error: %v", err)
 			}
+
+			if test.expected != "" && result != test.expected {
+				t.Errorf("findManifestURL() = %s, expected %s", result, test.expected)
+			}
 		})
 	}
 }
 
+func TestExtractFromIframeResolvesAgainstRedirect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/e/abc":
+			http.Redirect(w, r, "/player/v2/embed.html", http.StatusFound)
+		case "/player/v2/embed.html":
+			fmt.Fprint(w, `<script>var source = "hls/index.m3u8";</script>`)
+		case "/player/v2/hls/index.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXTINF:4.0,\nseg0.ts\n#EXT-X-ENDLIST\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	streamInfo, err := New(models.DefaultConfig()).ExtractFromIframe(server.URL + "/e/abc")
+	if err != nil {
+		t.Fatalf("ExtractFromIframe failed: %v", err)
+	}
+
+	if streamInfo.ManifestURL != server.URL+"/player/v2/hls/index.m3u8" {
+		t.Errorf("ManifestURL = %s", streamInfo.ManifestURL)
+	}
+
+	if streamInfo.Segments[0].URL != server.URL+"/player/v2/hls/seg0.ts" {
+		t.Errorf("Segment URL = %s", streamInfo.Segments[0].URL)
+	}
+}
+
 func TestParseManifest(t *testing.T) {
 	config := models.DefaultConfig()
 	ext := New(config)
@@ -147,3 +238,792 @@ segment003.ts
 		t.Errorf("Total duration = %v seconds, expected %v", streamInfo.Duration.Seconds(), expectedDuration)
 	}
 }
+
+func TestParseMasterPlaylist(t *testing.T) {
+	config := models.DefaultConfig()
+	ext := New(config)
+
+	manifestContent := `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS="avc1.4d401e,mp4a.40.2"
+360p/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720,CODECS="avc1.4d401f,mp4a.40.2",FRAME-RATE=29.970
+720p/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080,CODECS="avc1.640028,mp4a.40.2"
+https://cdn.example.com/1080p/index.m3u8`
+
+	if !isMasterPlaylist(manifestContent) {
+		t.Fatal("Expected content to be detected as master playlist")
+	}
+
+	variants, err := ext.parseMasterPlaylist(manifestContent, "https://example.com/video/master.m3u8?token=abc")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(variants) != 3 {
+		t.Fatalf("Expected 3 variants, got %d", len(variants))
+	}
+
+	expected := []models.Variant{
+		{URL: "https://example.com/video/360p/index.m3u8", Bandwidth: 800000, Width: 640, Height: 360, Codecs: "avc1.4d401e,mp4a.40.2"},
+		{URL: "https://example.com/video/720p/index.m3u8", Bandwidth: 2800000, Width: 1280, Height: 720, Codecs: "avc1.4d401f,mp4a.40.2", FrameRate: 29.97},
+		{URL: "https://cdn.example.com/1080p/index.m3u8", Bandwidth: 5000000, Width: 1920, Height: 1080, Codecs: "avc1.640028,mp4a.40.2"},
+	}
+
+	for i, variant := range variants {
+		if variant != expected[i] {
+			t.Errorf("Variant %d = %+v, expected %+v", i, variant, expected[i])
+		}
+	}
+
+	if err := ext.parseManifest(manifestContent, &models.StreamInfo{}); err == nil {
+		t.Error("Expected parseManifest to reject a master playlist")
+	}
+}
+
+func TestSelectVariant(t *testing.T) {
+	variants := []models.Variant{
+		{URL: "720.m3u8", Bandwidth: 2800000, Height: 720},
+		{URL: "360.m3u8", Bandwidth: 800000, Height: 360},
+		{URL: "1080.m3u8", Bandwidth: 5000000, Height: 1080},
+		{URL: "480.m3u8", Bandwidth: 1400000, Height: 480},
+	}
+
+	tests := []struct {
+		quality     string
+		expected    string
+		expectError bool
+	}{
+		{quality: "best", expected: "1080.m3u8"},
+		{quality: "", expected: "1080.m3u8"},
+		{quality: "worst", expected: "360.m3u8"},
+		{quality: "720p", expected: "720.m3u8"},
+		{quality: "600p", expected: "480.m3u8"},
+		{quality: "240p", expected: "360.m3u8"},
+		{quality: "2000k", expected: "480.m3u8"},
+		{quality: "3M", expected: "720.m3u8"},
+		{quality: "5000000", expected: "1080.m3u8"},
+		{quality: "100k", expected: "360.m3u8"},
+		{quality: "high", expectError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.quality, func(t *testing.T) {
+			variant, err := selectVariant(variants, test.quality)
+
+			if test.expectError {
+				if err == nil {
+					t.Error("Expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if variant.URL != test.expected {
+				t.Errorf("selectVariant(%q) = %s, expected %s", test.quality, variant.URL, test.expected)
+			}
+		})
+	}
+}
+
+func TestSelectAudioTrack(t *testing.T) {
+	master := `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",LANGUAGE="en",NAME="English",DEFAULT=YES,AUTOSELECT=YES,URI="audio/en/index.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",LANGUAGE="es-419",NAME="Español",DEFAULT=NO,URI="audio/es/index.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",LANGUAGE="en",NAME="Commentary",DEFAULT=NO,URI="audio/commentary/index.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="ac3",LANGUAGE="en",NAME="English 5.1",DEFAULT=YES,URI="audio/ac3/index.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",LANGUAGE="en",NAME="English",URI="subs/en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720,AUDIO="aac"
+720p/index.m3u8`
+
+	tracks := parseAudioRenditions(master, "https://example.com/video/master.m3u8")
+	if len(tracks) != 4 {
+		t.Fatalf("Expected 4 audio renditions, got %d", len(tracks))
+	}
+	if tracks[0].URL != "https://example.com/video/audio/en/index.m3u8" || tracks[0].GroupID != "aac" || !tracks[0].Default {
+		t.Errorf("Unexpected first rendition: %+v", tracks[0])
+	}
+
+	tests := []struct {
+		group    string
+		lang     string
+		expected string
+	}{
+		{group: "aac", lang: "", expected: "English"},
+		{group: "aac", lang: "es", expected: "Español"},
+		{group: "aac", lang: "ES-419", expected: "Español"},
+		{group: "aac", lang: "commentary", expected: "Commentary"},
+		{group: "aac", lang: "fr", expected: "English"},
+		{group: "ac3", lang: "es", expected: "English 5.1"},
+		{group: "", lang: "es", expected: "Español"},
+	}
+
+	for _, test := range tests {
+		track := selectAudioTrack(tracks, test.group, test.lang)
+		if track == nil {
+			t.Errorf("selectAudioTrack(%q, %q) returned nil", test.group, test.lang)
+			continue
+		}
+		if track.Name != test.expected {
+			t.Errorf("selectAudioTrack(%q, %q) = %s, expected %s", test.group, test.lang, track.Name, test.expected)
+		}
+	}
+
+	if track := selectAudioTrack(tracks, "missing", "en"); track != nil {
+		t.Errorf("Expected no track for unknown group, got %+v", track)
+	}
+}
+
+func TestExtractFromIframeAudioRendition(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/embed":
+			w.Write([]byte(`<script>var src = "/hls/master.m3u8";</script>`))
+		case "/hls/master.m3u8":
+			w.Write([]byte(`#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",LANGUAGE="en",NAME="English",DEFAULT=YES,URI="audio_en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",LANGUAGE="de",NAME="Deutsch",URI="audio_de.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1000000,RESOLUTION=1280x720,AUDIO="audio"
+video.m3u8
+`))
+		case "/hls/video.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXTINF:4.0,\nv0.ts\n#EXTINF:4.0,\nv1.ts\n#EXT-X-ENDLIST\n"))
+		case "/hls/audio_de.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXTINF:4.0,\nde0.aac\n#EXTINF:4.0,\nde1.aac\n#EXT-X-ENDLIST\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	config := models.DefaultConfig()
+	config.AudioLang = "de"
+
+	streamInfo, err := New(config).ExtractFromIframe(server.URL + "/embed")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(streamInfo.AudioTracks) != 2 {
+		t.Errorf("Expected 2 audio tracks, got %d", len(streamInfo.AudioTracks))
+	}
+	if streamInfo.Audio == nil || streamInfo.Audio.Language != "de" {
+		t.Fatalf("Expected German audio rendition, got %+v", streamInfo.Audio)
+	}
+	if len(streamInfo.Audio.Segments) != 2 {
+		t.Fatalf("Expected 2 audio segments, got %d", len(streamInfo.Audio.Segments))
+	}
+
+	audioSegment := streamInfo.Audio.Segments[1]
+	if audioSegment.URL != server.URL+"/hls/de1.aac" || audioSegment.Filename != "audio_segment_0001.aac" {
+		t.Errorf("Unexpected audio segment: %+v", audioSegment)
+	}
+	if streamInfo.Segments[1].Filename != "segment_0001.ts" {
+		t.Errorf("Unexpected video segment filename: %s", streamInfo.Segments[1].Filename)
+	}
+}
+
+func TestParseManifestEncryption(t *testing.T) {
+	config := models.DefaultConfig()
+	ext := New(config)
+
+	manifestContent := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-MEDIA-SEQUENCE:100
+#EXT-X-KEY:METHOD=AES-128,URI="key.bin"
+#EXTINF:10.0,
+segment100.ts
+#EXT-X-KEY:METHOD=AES-128,URI="https://keys.example.com/k2",IV=0x000102030405060708090a0b0c0d0e0f
+#EXTINF:10.0,
+segment101.ts
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:10.0,
+segment102.ts
+#EXT-X-ENDLIST`
+
+	streamInfo := &models.StreamInfo{
+		BaseURL: "https://example.com/video/",
+	}
+
+	if err := ext.parseManifest(manifestContent, streamInfo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(streamInfo.Segments) != 3 {
+		t.Fatalf("Expected 3 segments, got %d", len(streamInfo.Segments))
+	}
+
+	first := streamInfo.Segments[0]
+	if first.Key == nil || first.Key.URI != "https://example.com/video/key.bin" || first.Key.IV != "" {
+		t.Errorf("Segment 0 key = %+v, expected relative key URI without IV", first.Key)
+	}
+
+	second := streamInfo.Segments[1]
+	if second.Key == nil || second.Key.URI != "https://keys.example.com/k2" || second.Key.IV != "0x000102030405060708090a0b0c0d0e0f" {
+		t.Errorf("Segment 1 key = %+v, expected absolute key URI with IV", second.Key)
+	}
+
+	if streamInfo.Segments[2].Key != nil {
+		t.Errorf("Segment 2 key = %+v, expected nil after METHOD=NONE", streamInfo.Segments[2].Key)
+	}
+
+	for i, segment := range streamInfo.Segments {
+		if segment.Sequence != 100+i {
+			t.Errorf("Segment %d Sequence = %d, expected %d", i, segment.Sequence, 100+i)
+		}
+	}
+
+	unsupported := "#EXTM3U\n#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"skd://key\"\n#EXTINF:10.0,\nseg.ts\n"
+	if err := ext.parseManifest(unsupported, &models.StreamInfo{}); err == nil {
+		t.Error("Expected error for unsupported encryption method")
+	}
+}
+
+func TestParseManifestFragmentedMP4(t *testing.T) {
+	config := models.DefaultConfig()
+	ext := New(config)
+
+	manifestContent := `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:6
+#EXT-X-MAP:URI="init-v1.mp4"
+#EXTINF:6.0,
+chunk-1.m4s
+#EXTINF:6.0,
+chunk-2.m4s?token=abc
+#EXT-X-DISCONTINUITY
+#EXT-X-MAP:URI="https://cdn.example.com/init-v2.mp4"
+#EXTINF:6.0,
+chunk-3
+#EXT-X-ENDLIST`
+
+	streamInfo := &models.StreamInfo{
+		BaseURL: "https://example.com/video/",
+	}
+
+	if err := ext.parseManifest(manifestContent, streamInfo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expectedInits := []models.Segment{
+		{URL: "https://example.com/video/init-v1.mp4", Index: 0, Filename: "init_00.mp4"},
+		{URL: "https://cdn.example.com/init-v2.mp4", Index: 1, Sequence: 2, Filename: "init_01.mp4"},
+	}
+
+	if len(streamInfo.InitSegments) != len(expectedInits) {
+		t.Fatalf("Expected %d init segments, got %d", len(expectedInits), len(streamInfo.InitSegments))
+	}
+
+	for i, init := range streamInfo.InitSegments {
+		if init != expectedInits[i] {
+			t.Errorf("Init segment %d = %+v, expected %+v", i, init, expectedInits[i])
+		}
+	}
+
+	expectedSegments := []struct {
+		filename string
+		init     string
+	}{
+		{filename: "segment_0000.m4s", init: "init_00.mp4"},
+		{filename: "segment_0001.m4s", init: "init_00.mp4"},
+		{filename: "segment_0002.m4s", init: "init_01.mp4"},
+	}
+
+	for i, segment := range streamInfo.Segments {
+		if segment.Filename != expectedSegments[i].filename {
+			t.Errorf("Segment %d Filename = %s, expected %s", i, segment.Filename, expectedSegments[i].filename)
+		}
+
+		if segment.InitFilename != expectedSegments[i].init {
+			t.Errorf("Segment %d InitFilename = %s, expected %s", i, segment.InitFilename, expectedSegments[i].init)
+		}
+	}
+}
+
+func TestParseManifestByteRange(t *testing.T) {
+	config := models.DefaultConfig()
+	ext := New(config)
+
+	manifestContent := `#EXTM3U
+#EXT-X-VERSION:4
+#EXT-X-MAP:URI="main.mp4",BYTERANGE="720@0"
+#EXTINF:10.0,
+#EXT-X-BYTERANGE:75232@720
+main.mp4
+#EXTINF:10.0,
+#EXT-X-BYTERANGE:82112
+main.mp4
+#EXTINF:10.0,
+#EXT-X-BYTERANGE:69864
+main.mp4
+#EXTINF:10.0,
+other.ts
+#EXT-X-ENDLIST`
+
+	streamInfo := &models.StreamInfo{
+		BaseURL: "https://example.com/video/",
+	}
+
+	if err := ext.parseManifest(manifestContent, streamInfo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	init := streamInfo.InitSegments[0]
+	if init.ByteOffset != 0 || init.ByteLength != 720 {
+		t.Errorf("Init segment range = %d@%d, expected 720@0", init.ByteLength, init.ByteOffset)
+	}
+
+	expected := []struct {
+		offset int64
+		length int64
+	}{
+		{offset: 720, length: 75232},
+		{offset: 75952, length: 82112},
+		{offset: 158064, length: 69864},
+		{offset: 0, length: 0},
+	}
+
+	if len(streamInfo.Segments) != len(expected) {
+		t.Fatalf("Expected %d segments, got %d", len(expected), len(streamInfo.Segments))
+	}
+
+	for i, segment := range streamInfo.Segments {
+		if segment.ByteOffset != expected[i].offset || segment.ByteLength != expected[i].length {
+			t.Errorf("Segment %d range = %d@%d, expected %d@%d", i,
+				segment.ByteLength, segment.ByteOffset, expected[i].length, expected[i].offset)
+		}
+	}
+
+	invalid := "#EXTM3U\n#EXTINF:10.0,\n#EXT-X-BYTERANGE:abc\nmain.mp4\n"
+	if err := ext.parseManifest(invalid, &models.StreamInfo{}); err == nil {
+		t.Error("Expected error for invalid byte range")
+	}
+}
+
+func TestParseManifestLive(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		live     bool
+	}{
+		{
+			name:     "sliding window",
+			manifest: "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:2680\n#EXTINF:6.0,\na.ts\n#EXTINF:6.0,\nb.ts\n",
+			live:     true,
+		},
+		{
+			name:     "event",
+			manifest: "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXTINF:6.0,\na.ts\n",
+			live:     true,
+		},
+		{
+			name:     "ended",
+			manifest: "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.0,\na.ts\n#EXT-X-ENDLIST\n",
+			live:     false,
+		},
+		{
+			name:     "vod without endlist",
+			manifest: "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:6.0,\na.ts\n",
+			live:     false,
+		},
+	}
+
+	ext := New(models.DefaultConfig())
+	for _, test := range tests {
+		streamInfo := &models.StreamInfo{BaseURL: "https://example.com/live/"}
+		if err := ext.parseManifest(test.manifest, streamInfo); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if streamInfo.IsLive != test.live {
+			t.Errorf("%s: IsLive = %v, expected %v", test.name, streamInfo.IsLive, test.live)
+		}
+		if streamInfo.TargetDuration != 6*time.Second {
+			t.Errorf("%s: TargetDuration = %v, expected 6s", test.name, streamInfo.TargetDuration)
+		}
+	}
+}
+
+func TestRefreshPlaylist(t *testing.T) {
+	var mu sync.Mutex
+	sequence := 10
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		fmt.Fprintf(w, "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "#EXTINF:4.0,\nseg%d.ts\n", sequence+i)
+		}
+		sequence++
+	}))
+	defer server.Close()
+
+	ext := New(models.DefaultConfig())
+	streamInfo := &models.StreamInfo{PlaylistURL: server.URL + "/live/index.m3u8", Format: "hls"}
+
+	for _, expected := range []int{10, 11} {
+		refreshed, err := ext.RefreshPlaylist(streamInfo)
+		if err != nil {
+			t.Fatalf("RefreshPlaylist failed: %v", err)
+		}
+
+		if !refreshed.IsLive || len(refreshed.Segments) != 3 {
+			t.Fatalf("Expected a live window of 3 segments, got live=%v with %d", refreshed.IsLive, len(refreshed.Segments))
+		}
+		first := refreshed.Segments[0]
+		if first.Sequence != expected || first.URL != fmt.Sprintf("%s/live/seg%d.ts", server.URL, expected) {
+			t.Errorf("First segment = %d %s, expected sequence %d", first.Sequence, first.URL, expected)
+		}
+	}
+}
+
+func TestPageTitles(t *testing.T) {
+	tests := []struct {
+		name             string
+		content          string
+		expectedPrimary  string
+		expectedFallback string
+	}{
+		{
+			name: "JSON-LD VideoObject wins over og:title",
+			content: `<html><head><title>Watch online | Site</title>
+<meta property="og:title" content="OG Title">
+<script type="application/ld+json">{"@context":"https://schema.org","@graph":[{"@type":"WebPage","name":"Page"},{"@type":"VideoObject","name":"The Real &amp; Only Title"}]}</script>
+</head></html>`,
+			expectedPrimary:  "The Real & Only Title",
+			expectedFallback: "Watch online | Site",
+		},
+		{
+			name:            "og:title with content before property",
+			content:         `<meta content="Episode 4: The Return" property="og:title" /><meta name="twitter:title" content="Twitter">`,
+			expectedPrimary: "Episode 4: The Return",
+		},
+		{
+			name:            "Player config title",
+			content:         `<script>jwplayer("p").setup({file: "x.m3u8", title: "Player Title"});</script>`,
+			expectedPrimary: "Player Title",
+		},
+		{
+			name:             "Only document title",
+			content:          "<title>\n  Just   a page\n</title>",
+			expectedFallback: "Just a page",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			primary, fallback := pageTitles(test.content)
+			if primary != test.expectedPrimary {
+				t.Errorf("primary = %q, expected %q", primary, test.expectedPrimary)
+			}
+			if fallback != test.expectedFallback {
+				t.Errorf("fallback = %q, expected %q", fallback, test.expectedFallback)
+			}
+		})
+	}
+}
+
+func TestExtractFromIframeTitle(t *testing.T) {
+	var serverURL string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/embed":
+			fmt.Fprintf(w, `<html><head><title>Embed Player</title></head><script>var src = "%s/master.m3u8";</script></html>`, serverURL)
+		case "/master.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-SESSION-DATA:DATA-ID=\"com.apple.hls.title\",VALUE=\"Session Title\"\n#EXT-X-STREAM-INF:BANDWIDTH=1000\nlow.m3u8\n")
+		case "/low.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXTINF:10.0,\nseg.ts\n#EXT-X-ENDLIST\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	serverURL = server.URL
+
+	config := models.DefaultConfig()
+	streamInfo, err := New(config).ExtractFromIframe(server.URL + "/embed")
+	if err != nil {
+		t.Fatalf("ExtractFromIframe failed: %v", err)
+	}
+
+	if streamInfo.Title != "Session Title" {
+		t.Errorf("Title = %q, expected session data title to win over <title>", streamInfo.Title)
+	}
+
+	config.Title = "Override"
+	streamInfo, err = New(config).ExtractFromIframe(server.URL + "/embed")
+	if err != nil {
+		t.Fatalf("ExtractFromIframe failed: %v", err)
+	}
+
+	if streamInfo.Title != "Override" {
+		t.Errorf("Title = %q, expected configured override", streamInfo.Title)
+	}
+}
+
+func TestExtractFromIframeFollowsNestedPages(t *testing.T) {
+	var serverURL string
+	var mu sync.Mutex
+	referers := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		referers[r.URL.Path] = r.Header.Get("Referer")
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/article":
+			fmt.Fprint(w, `<html><head><meta property="og:title" content="Outer Title"></head>
+<body><iframe src="about:blank"></iframe><iframe width="640" src="/wrapper?id=1"></iframe></body></html>`)
+		case "/wrapper":
+			fmt.Fprint(w, `<meta http-equiv="refresh" content="0; url=/redirect">`)
+		case "/redirect":
+			fmt.Fprint(w, `<script>window.location.href = "/player/embed";</script>`)
+		case "/player/embed":
+			fmt.Fprint(w, `<title>Player</title><script>var src = "hls/master.m3u8";</script>`)
+		case "/player/hls/master.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXTINF:4.0,\nseg0.ts\n#EXT-X-ENDLIST\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	serverURL = server.URL
+
+	streamInfo, err := New(models.DefaultConfig()).ExtractFromIframe(serverURL + "/article")
+	if err != nil {
+		t.Fatalf("ExtractFromIframe failed: %v", err)
+	}
+
+	expectedChain := []string{
+		serverURL + "/article",
+		serverURL + "/wrapper?id=1",
+		serverURL + "/redirect",
+		serverURL + "/player/embed",
+	}
+
+	if len(streamInfo.Chain) != len(expectedChain) {
+		t.Fatalf("Chain = %v, expected %v", streamInfo.Chain, expectedChain)
+	}
+
+	for i, page := range expectedChain {
+		if streamInfo.Chain[i] != page {
+			t.Errorf("Chain[%d] = %s, expected %s", i, streamInfo.Chain[i], page)
+		}
+	}
+
+	expectedReferers := map[string]string{
+		"/wrapper":                serverURL + "/article",
+		"/redirect":               serverURL + "/wrapper?id=1",
+		"/player/embed":           serverURL + "/redirect",
+		"/player/hls/master.m3u8": serverURL + "/player/embed",
+	}
+
+	mu.Lock()
+	for path, referer := range expectedReferers {
+		if referers[path] != referer {
+			t.Errorf("Referer for %s = %s, expected %s", path, referers[path], referer)
+		}
+	}
+	mu.Unlock()
+
+	if streamInfo.Title != "Outer Title" {
+		t.Errorf("Title = %q, expected title from the outermost page", streamInfo.Title)
+	}
+
+	config := models.DefaultConfig()
+	config.MaxIframeDepth = 2
+	if _, err := New(config).ExtractFromIframe(serverURL + "/article"); err == nil {
+		t.Error("Expected error when the manifest is deeper than MaxIframeDepth")
+	}
+}
+
+type fakeSiteExtractor struct {
+	name        string
+	pathPrefix  string
+	manifestURL string
+	headers     map[string]string
+	err         error
+	calls       int
+}
+
+func (f *fakeSiteExtractor) Name() string {
+	return f.name
+}
+
+func (f *fakeSiteExtractor) Match(pageURL *url.URL) bool {
+	return strings.HasPrefix(pageURL.Path, f.pathPrefix)
+}
+
+func (f *fakeSiteExtractor) Extract(e *Extractor, pageURL string) (*models.StreamInfo, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &models.StreamInfo{ManifestURL: f.manifestURL, Title: "From " + f.name, Headers: f.headers}, nil
+}
+
+func TestSiteExtractorRegistry(t *testing.T) {
+	registryMu.Lock()
+	saved := registry
+	registry = nil
+	registryMu.Unlock()
+	defer func() {
+		registryMu.Lock()
+		registry = saved
+		registryMu.Unlock()
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/site/watch", "/broken/watch", "/token/watch":
+			fmt.Fprint(w, `<script>var src = "/generic.m3u8";</script>`)
+		case "/site.m3u8", "/generic.m3u8":
+			if r.URL.Path == "/generic.m3u8" && r.Header.Get("X-Token") != "" {
+				t.Errorf("Expected the failed site extractor's headers to be dropped, got X-Token %q", r.Header.Get("X-Token"))
+			}
+			fmt.Fprint(w, "#EXTM3U\n#EXTINF:4.0,\nseg0.ts\n#EXT-X-ENDLIST\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	site := &fakeSiteExtractor{name: "site", pathPrefix: "/site/", manifestURL: server.URL + "/site.m3u8"}
+	broken := &fakeSiteExtractor{name: "broken", pathPrefix: "/broken/", err: fmt.Errorf("layout changed")}
+	token := &fakeSiteExtractor{name: "token", pathPrefix: "/token/", manifestURL: server.URL + "/expired.m3u8", headers: map[string]string{"X-Token": "secret"}}
+	Register(site)
+	Register(broken)
+	Register(token)
+
+	names := []string{}
+	for _, registered := range Registered() {
+		names = append(names, registered.Name())
+	}
+	if strings.Join(names, ",") != "broken,site,token" {
+		t.Errorf("Registered() = %v, expected sorted names", names)
+	}
+
+	ext := New(models.DefaultConfig())
+
+	streamInfo, err := ext.ExtractFromIframe(server.URL + "/site/watch")
+	if err != nil {
+		t.Fatalf("ExtractFromIframe failed: %v", err)
+	}
+	if streamInfo.ManifestURL != server.URL+"/site.m3u8" || streamInfo.Title != "From site" || len(streamInfo.Segments) != 1 {
+		t.Errorf("Expected site extractor result, got %+v", streamInfo)
+	}
+
+	streamInfo, err = ext.ExtractFromIframe(server.URL + "/broken/watch")
+	if err != nil {
+		t.Fatalf("ExtractFromIframe failed: %v", err)
+	}
+	if broken.calls != 1 || streamInfo.ManifestURL != server.URL+"/generic.m3u8" {
+		t.Errorf("Expected fallback to generic extraction, got %s", streamInfo.ManifestURL)
+	}
+
+	streamInfo, err = New(models.DefaultConfig()).ExtractFromIframe(server.URL + "/token/watch")
+	if err != nil {
+		t.Fatalf("ExtractFromIframe failed: %v", err)
+	}
+	if token.calls != 1 || streamInfo.ManifestURL != server.URL+"/generic.m3u8" || streamInfo.Headers["X-Token"] != "" {
+		t.Errorf("Expected fallback to generic extraction without the site's headers, got %+v", streamInfo)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected Register to panic on duplicate name")
+		}
+	}()
+	Register(&fakeSiteExtractor{name: "site"})
+}
+
+func TestParseManifestOffsetsAndProgramDateTime(t *testing.T) {
+	manifest := `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-PROGRAM-DATE-TIME:2024-05-01T18:00:00.000Z
+#EXTINF:6.0,
+a.ts
+#EXTINF:4.5,
+b.ts
+#EXT-X-DISCONTINUITY
+#EXT-X-PROGRAM-DATE-TIME:2024-05-01T18:05:00.000+0000
+#EXTINF:6.0,
+c.ts
+#EXT-X-ENDLIST
+`
+
+	ext := New(models.DefaultConfig())
+	streamInfo := &models.StreamInfo{BaseURL: "https://example.com/vod/"}
+	if err := ext.parseManifest(manifest, streamInfo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	start := time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)
+	expected := []struct {
+		offset float64
+		date   time.Time
+	}{
+		{0, start},
+		{6, start.Add(6 * time.Second)},
+		{10.5, start.Add(5 * time.Minute)},
+	}
+	for i, segment := range streamInfo.Segments {
+		if segment.Offset != expected[i].offset || !segment.ProgramDateTime.Equal(expected[i].date) {
+			t.Errorf("Segment %d: offset %v date %v, expected %v %v", i, segment.Offset, segment.ProgramDateTime, expected[i].offset, expected[i].date)
+		}
+	}
+}
+
+// TestReplayDumps replays every extraction recorded with --dump-pages under
+// testdata/dumps. A site that broke is turned into a regression test by
+// dumping its extraction there and writing, next to it, an expected.json with
+// the fields of "stream-snatchet info --json" the replay must reproduce.
+// Fields left out of expected.json are not checked.
+func TestReplayDumps(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("testdata", "dumps", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) == 0 {
+		t.Fatal("Expected dumps in testdata/dumps")
+	}
+
+	for _, dir := range dirs {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join(dir, "expected.json"))
+			if err != nil {
+				t.Fatalf("Failed to read expected.json: %v", err)
+			}
+			var expected map[string]interface{}
+			if err := json.Unmarshal(data, &expected); err != nil {
+				t.Fatalf("Failed to parse expected.json: %v", err)
+			}
+
+			streamInfo, err := New(models.DefaultConfig()).Replay(dir)
+			if err != nil {
+				t.Fatalf("Replay failed: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if err := info.WriteJSON(&buf, info.Summarize(streamInfo)); err != nil {
+				t.Fatal(err)
+			}
+			var actual map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &actual); err != nil {
+				t.Fatal(err)
+			}
+
+			for key, want := range expected {
+				if got, ok := actual[key]; !ok || !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: got %v, expected %v", key, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package extractor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// harCapture is the part of an HTTP Archive, as saved by the network panel
+// of browser devtools, needed to replay its manifest requests.
+type harCapture struct {
+	Log struct {
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harEntry struct {
+	Request struct {
+		Method  string         `json:"method"`
+		URL     string         `json:"url"`
+		Headers []harNameValue `json:"headers"`
+		Cookies []harCookie    `json:"cookies"`
+	} `json:"request"`
+	Response struct {
+		Status  int `json:"status"`
+		Content struct {
+			MimeType string `json:"mimeType"`
+			Text     string `json:"text"`
+			Encoding string `json:"encoding"`
+		} `json:"content"`
+	} `json:"response"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harCookie struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Path   string `json:"path"`
+	Domain string `json:"domain"`
+}
+
+// harSkippedHeaders are request headers that describe the captured
+// connection or response cache rather than what the server requires. Cookie
+// is rebuilt from the captured cookies instead.
+var harSkippedHeaders = map[string]bool{
+	"Host":                      true,
+	"Connection":                true,
+	"Keep-Alive":                true,
+	"Content-Length":            true,
+	"Accept-Encoding":           true,
+	"Cookie":                    true,
+	"Range":                     true,
+	"If-None-Match":             true,
+	"If-Modified-Since":         true,
+	"If-Range":                  true,
+	"Te":                        true,
+	"Upgrade-Insecure-Requests": true,
+	"Proxy-Authorization":       true,
+}
+
+// IsHARFile reports whether input names an HTTP Archive on disk.
+func IsHARFile(input string) bool {
+	if !strings.EqualFold(filepath.Ext(input), ".har") {
+		return false
+	}
+	info, err := os.Stat(input)
+	return err == nil && !info.IsDir()
+}
+
+// ExtractFromHAR extracts stream information from a HAR file captured while
+// the video played in a browser. The manifest request is picked from the
+// capture, and its headers and the cookies of every captured request are
+// loaded into the session, so the manifest and segments are fetched the way
+// the browser fetched them.
+func (e *Extractor) ExtractFromHAR(path string) (*models.StreamInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HAR file: %w", err)
+	}
+
+	var capture harCapture
+	if err := json.Unmarshal(data, &capture); err != nil {
+		return nil, fmt.Errorf("failed to parse HAR file %s: %w", path, err)
+	}
+
+	entry, count := harManifestEntry(capture.Log.Entries)
+	if entry == nil {
+		return nil, fmt.Errorf("no HLS or DASH manifest request found in %s", path)
+	}
+	if e.config.Verbose {
+		fmt.Printf("Found %d manifest requests in %s, using %s\n", count, path, entry.Request.URL)
+	}
+
+	e.loadHARCookies(capture.Log.Entries)
+
+	for _, header := range entry.Request.Headers {
+		name := http.CanonicalHeaderKey(header.Name)
+		if strings.HasPrefix(header.Name, ":") || harSkippedHeaders[name] {
+			continue
+		}
+		e.session.SetHeader(name, header.Value)
+	}
+
+	streamInfo := &models.StreamInfo{
+		IframeURL:   path,
+		ManifestURL: entry.Request.URL,
+		Headers:     make(map[string]string),
+	}
+
+	if referer := e.session.Header("Referer"); referer != "" {
+		streamInfo.IframeURL = referer
+		streamInfo.Chain = []string{referer}
+		streamInfo.Title, _ = harPageTitles(capture.Log.Entries, referer)
+	}
+
+	if err := e.loadManifest(streamInfo); err != nil {
+		return nil, err
+	}
+	streamInfo.Headers = e.session.Headers()
+
+	if streamInfo.Title == "" && len(streamInfo.Chain) > 0 {
+		_, streamInfo.Title = harPageTitles(capture.Log.Entries, streamInfo.Chain[0])
+	}
+
+	e.finishExtraction(streamInfo)
+	return streamInfo, nil
+}
+
+// harManifestEntry returns the manifest request to download and the number
+// of manifest requests in the capture. A master playlist or MPD is
+// preferred over media playlists, which may belong to an audio or subtitle
+// rendition; otherwise the first manifest requested wins.
+func harManifestEntry(entries []harEntry) (*harEntry, int) {
+	var first, top *harEntry
+	count := 0
+
+	for i := range entries {
+		entry := &entries[i]
+		if !harSucceeded(entry) {
+			continue
+		}
+
+		kind := kindFromURL(entry.Request.URL)
+		if kind != kindHLS && kind != kindDASH {
+			kind = kindFromContentType(entry.Response.Content.MimeType)
+		}
+		if kind != kindHLS && kind != kindDASH {
+			continue
+		}
+
+		count++
+		if first == nil {
+			first = entry
+		}
+		if top == nil && (kind == kindDASH || isMasterPlaylist(harContent(entry))) {
+			top = entry
+		}
+	}
+
+	if top != nil {
+		return top, count
+	}
+	return first, count
+}
+
+// harSucceeded reports whether an entry is a GET request that got a
+// response. Browsers record a status of 0 for responses served from cache.
+func harSucceeded(entry *harEntry) bool {
+	if entry.Request.Method != "" && !strings.EqualFold(entry.Request.Method, http.MethodGet) {
+		return false
+	}
+	status := entry.Response.Status
+	return status == 0 || status == http.StatusNotModified || (status >= 200 && status < 300)
+}
+
+// harContent returns the captured response body of an entry, if devtools
+// saved it.
+func harContent(entry *harEntry) string {
+	content := entry.Response.Content
+	if content.Encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(content.Text)
+		if err != nil {
+			return ""
+		}
+		return string(decoded)
+	}
+	return content.Text
+}
+
+// loadHARCookies adds the cookies sent with each captured request to the
+// session's jar for that request's URL, so every host of the stream gets
+// the cookies the browser sent to it.
+func (e *Extractor) loadHARCookies(entries []harEntry) {
+	for _, entry := range entries {
+		if len(entry.Request.Cookies) == 0 {
+			continue
+		}
+
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			continue
+		}
+
+		var cookies []*http.Cookie
+		for _, c := range entry.Request.Cookies {
+			cookie := &http.Cookie{Name: c.Name, Value: c.Value, Path: c.Path, Domain: c.Domain}
+			if cookie.Path == "" {
+				cookie.Path = "/"
+			}
+			cookies = append(cookies, cookie)
+		}
+		e.session.Jar().SetCookies(u, cookies)
+	}
+}
+
+// harPageTitles returns the titles of the captured page at pageURL, if its
+// HTML was saved.
+func harPageTitles(entries []harEntry, pageURL string) (string, string) {
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Request.URL == pageURL && strings.Contains(entry.Response.Content.MimeType, "html") {
+			return pageTitles(harContent(entry))
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,108 @@
+package extractor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+const testHAR = `{"log": {"version": "1.2", "entries": [
+  {
+    "request": {"method": "GET", "url": "SERVER/watch/42", "headers": [], "cookies": []},
+    "response": {"status": 200, "content": {"mimeType": "text/html; charset=utf-8",
+      "text": "<html><head><meta property=\"og:title\" content=\"Episode 42\"></head></html>"}}
+  },
+  {
+    "request": {"method": "POST", "url": "SERVER/api/master.m3u8", "headers": [], "cookies": []},
+    "response": {"status": 200, "content": {"mimeType": "application/vnd.apple.mpegurl", "text": ""}}
+  },
+  {
+    "request": {"method": "GET", "url": "SERVER/hls/audio.m3u8", "headers": [], "cookies": []},
+    "response": {"status": 200, "content": {"mimeType": "application/vnd.apple.mpegurl",
+      "text": "#EXTM3U\n#EXTINF:6.0,\na0.aac\n#EXT-X-ENDLIST\n"}}
+  },
+  {
+    "request": {
+      "method": "GET",
+      "url": "SERVER/hls/master.m3u8?token=abc",
+      "headers": [
+        {"name": ":authority", "value": "example.com"},
+        {"name": "accept-encoding", "value": "gzip, br"},
+        {"name": "authorization", "value": "Bearer captured"},
+        {"name": "referer", "value": "SERVER/watch/42"},
+        {"name": "cookie", "value": "session=abc"},
+        {"name": "if-none-match", "value": "\"etag\""}
+      ],
+      "cookies": [{"name": "session", "value": "abc"}]
+    },
+    "response": {"status": 200, "content": {"mimeType": "application/x-mpegURL",
+      "text": "I0VYVE0zVQojRVhULVgtU1RSRUFNLUlORjpCQU5EV0lEVEg9MTAwMDAwMAp2aWRlby5tM3U4Cg==", "encoding": "base64"}}
+  }
+]}}`
+
+func TestExtractFromHAR(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session")
+		if err != nil || cookie.Value != "abc" || r.Header.Get("Authorization") != "Bearer captured" ||
+			r.Header.Get("If-None-Match") != "" || r.Header.Get(":authority") != "" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/hls/master.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000000\nvideo.m3u8\n"))
+		case "/hls/video.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.0,\nv0.ts\n#EXTINF:6.0,\nv1.ts\n#EXT-X-ENDLIST\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "capture.har")
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(testHAR, "SERVER", server.URL)), 0644); err != nil {
+		t.Fatalf("Failed to write HAR file: %v", err)
+	}
+
+	if !IsHARFile(path) || IsHARFile(server.URL+"/capture.har") {
+		t.Error("Expected only the file on disk to be taken for a HAR file")
+	}
+
+	streamInfo, err := New(models.DefaultConfig()).Extract(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if streamInfo.ManifestURL != server.URL+"/hls/master.m3u8?token=abc" {
+		t.Errorf("Expected the master playlist to be picked, got %s", streamInfo.ManifestURL)
+	}
+	if len(streamInfo.Segments) != 2 {
+		t.Errorf("Expected 2 segments, got %d", len(streamInfo.Segments))
+	}
+	if streamInfo.Title != "Episode 42" || streamInfo.IframeURL != server.URL+"/watch/42" {
+		t.Errorf("Expected the captured page's title and URL, got %q from %s", streamInfo.Title, streamInfo.IframeURL)
+	}
+	if streamInfo.Headers["Authorization"] != "Bearer captured" || streamInfo.Headers["Referer"] != server.URL+"/watch/42" {
+		t.Errorf("Expected the captured request headers, got %v", streamInfo.Headers)
+	}
+	if _, ok := streamInfo.Headers["Accept-Encoding"]; ok {
+		t.Errorf("Expected Accept-Encoding to be dropped, got %v", streamInfo.Headers)
+	}
+}
+
+func TestExtractFromHARWithoutManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.har")
+	if err := os.WriteFile(path, []byte(`{"log": {"entries": []}}`), 0644); err != nil {
+		t.Fatalf("Failed to write HAR file: %v", err)
+	}
+
+	if _, err := New(models.DefaultConfig()).ExtractFromHAR(path); err == nil {
+		t.Error("Expected an error for a capture without manifest requests")
+	}
+}
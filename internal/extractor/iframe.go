@@ -0,0 +1,196 @@
+package extractor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+const maxCrawledPages = 20
+
+var (
+	iframeSrcPattern   = regexp.MustCompile(`(?is)<iframe\b[^>]*?\s(?:data-)?src\s*=\s*["']([^"']+)["']`)
+	metaRefreshPattern = regexp.MustCompile(`(?is)<meta\b[^>]*http-equiv\s*=\s*["']?refresh["']?[^>]*content\s*=\s*["'][^"']*?url\s*=\s*['"]?([^"'>\s]+)`)
+	jsLocationPattern  = regexp.MustCompile(`(?:window\.|document\.|top\.|self\.)?location(?:\.href)?\s*=\s*["']([^"']+)["']|location\.(?:replace|assign)\(\s*["']([^"']+)["']\s*\)`)
+)
+
+// crawlResult describes the page in which a manifest URL was found and how
+// that page was reached from the original iframe URL. When a fetched URL
+// turns out to be a manifest or media file itself, manifestURL is that URL
+// and progressive tells whether it is a plain media file.
+type crawlResult struct {
+	manifestURL   string
+	progressive   bool
+	pageURL       string
+	chain         []string
+	subtitles     []models.Track
+	candidates    []models.Candidate
+	title         string
+	fallbackTitle string
+}
+
+type crawler struct {
+	e        *Extractor
+	maxDepth int
+	visited  map[string]bool
+	titles   []string
+	fallback []string
+}
+
+// crawl fetches the page and looks for a manifest URL, following nested
+// iframes, meta refreshes and JavaScript location redirects up to the
+// configured depth. Each hop is requested with its parent page as Referer.
+func (e *Extractor) crawl(pageURL string) (*crawlResult, error) {
+	c := newCrawler(e)
+	return c.finish(c.visit(pageURL, "", 0, nil))
+}
+
+// crawlContent is like crawl but starts from a page that was already
+// fetched, resolving its relative URLs against pageURL. Nested pages are
+// still fetched.
+func (e *Extractor) crawlContent(content, pageURL string) (*crawlResult, error) {
+	c := newCrawler(e)
+	c.visited[pageURL] = true
+	return c.finish(c.inspect(content, pageURL, 0, nil))
+}
+
+func newCrawler(e *Extractor) *crawler {
+	return &crawler{
+		e:        e,
+		maxDepth: e.config.MaxIframeDepth,
+		visited:  make(map[string]bool),
+	}
+}
+
+// finish adds the titles collected along the way to the result of a crawl.
+func (c *crawler) finish(result *crawlResult, err error) (*crawlResult, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	result.title = firstNonEmpty(c.titles)
+	result.fallbackTitle = firstNonEmpty(c.fallback)
+	return result, nil
+}
+
+func (c *crawler) visit(pageURL, referer string, depth int, chain []string) (*crawlResult, error) {
+	c.visited[pageURL] = true
+
+	content, finalURL, kind, err := c.e.fetchPage(pageURL, referer)
+	if err != nil {
+		if depth == 0 {
+			return nil, fmt.Errorf("failed to fetch iframe content: %w", err)
+		}
+		return nil, fmt.Errorf("failed to fetch nested page %s: %w", pageURL, err)
+	}
+	c.visited[finalURL] = true
+
+	if kind != kindPage {
+		// The page embedding it, if any, is the Referer players send.
+		return &crawlResult{
+			manifestURL: finalURL,
+			progressive: kind == kindProgressive,
+			pageURL:     referer,
+			chain:       chain,
+		}, nil
+	}
+
+	return c.inspect(content, finalURL, depth, chain)
+}
+
+// inspect looks for a manifest in a fetched page and, failing that, visits
+// the pages it hands off to.
+func (c *crawler) inspect(content, finalURL string, depth int, chain []string) (*crawlResult, error) {
+	chain = append(append([]string{}, chain...), finalURL)
+
+	title, fallbackTitle := pageTitles(content)
+	c.titles = append(c.titles, title)
+	c.fallback = append(c.fallback, fallbackTitle)
+
+	discovery, findErr := discoverManifest(content, finalURL)
+	if findErr == nil {
+		return &crawlResult{
+			manifestURL: discovery.manifestURL,
+			pageURL:     finalURL,
+			chain:       chain,
+			subtitles:   discovery.subtitles,
+			candidates:  discovery.candidates,
+		}, nil
+	}
+
+	if depth >= c.maxDepth {
+		return nil, fmt.Errorf("failed to find manifest URL: %w", findErr)
+	}
+
+	var lastErr error
+	nested := strings.Join(append([]string{content}, deobfuscate(content)...), "\n")
+	for _, next := range findNestedPages(nested, finalURL) {
+		if c.visited[next] || len(c.visited) >= maxCrawledPages {
+			continue
+		}
+
+		result, err := c.visit(next, finalURL, depth+1, chain)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("failed to find manifest URL: %w", findErr)
+}
+
+// findNestedPages returns the pages a document hands off to, in the order
+// they should be tried: meta refreshes, iframes, then scripted redirects.
+func findNestedPages(content, baseURL string) []string {
+	content = unescapeContent(content)
+
+	var candidates []string
+	for _, matches := range metaRefreshPattern.FindAllStringSubmatch(content, -1) {
+		candidates = append(candidates, matches[1])
+	}
+	for _, matches := range iframeSrcPattern.FindAllStringSubmatch(content, -1) {
+		candidates = append(candidates, matches[1])
+	}
+	for _, matches := range jsLocationPattern.FindAllStringSubmatch(content, -1) {
+		candidates = append(candidates, matches[1]+matches[2])
+	}
+
+	seen := make(map[string]bool)
+	var pages []string
+	for _, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		lower := strings.ToLower(candidate)
+		if candidate == "" || strings.HasPrefix(candidate, "#") ||
+			strings.HasPrefix(lower, "about:") ||
+			strings.HasPrefix(lower, "javascript:") ||
+			strings.HasPrefix(lower, "data:") {
+			continue
+		}
+
+		resolved := resolveURL(baseURL, candidate)
+		if !strings.HasPrefix(resolved, "http://") && !strings.HasPrefix(resolved, "https://") {
+			continue
+		}
+
+		if !seen[resolved] {
+			seen[resolved] = true
+			pages = append(pages, resolved)
+		}
+	}
+
+	return pages
+}
+
+func firstNonEmpty(values []string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
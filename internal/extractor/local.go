@@ -0,0 +1,61 @@
+package extractor
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// savedFromPattern matches the comment browsers add to pages saved with
+// "Save Page As", recording the URL the page was loaded from.
+var savedFromPattern = regexp.MustCompile(`(?i)<!--\s*saved from url=\(\d+\)(\S+?)\s*-->`)
+
+// isLocalFile reports whether input is the path of a file rather than a URL.
+func isLocalFile(input string) bool {
+	if strings.Contains(input, "://") {
+		return false
+	}
+	info, err := os.Stat(input)
+	return err == nil && !info.IsDir()
+}
+
+// ExtractFromHTML runs the manifest discovery on a page that was obtained
+// some other way, such as saved from a browser session or piped from another
+// tool. Relative URLs in the page are resolved against baseURL, which is
+// also sent as the Referer; without one, the URL a browser recorded when
+// saving the page is used. Nested pages, manifests and segments are still
+// fetched over HTTP.
+func (e *Extractor) ExtractFromHTML(content, baseURL string) (*models.StreamInfo, error) {
+	if baseURL == "" {
+		baseURL = savedFromURL(content)
+	}
+	u, err := url.Parse(baseURL)
+	if baseURL == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("a base URL such as https://example.com/embed/123 is required to resolve the page's URLs, got %q", baseURL)
+	}
+
+	result, err := e.crawlContent(content, baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	streamInfo, err := e.loadCrawlResult(baseURL, result)
+	if err != nil {
+		return nil, err
+	}
+
+	e.finishExtraction(streamInfo)
+	return streamInfo, nil
+}
+
+// savedFromURL returns the URL recorded in a "saved from url" comment.
+func savedFromURL(content string) string {
+	if matches := savedFromPattern.FindStringSubmatch(content); len(matches) > 1 {
+		return matches[1]
+	}
+	return ""
+}
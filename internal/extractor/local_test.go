@@ -0,0 +1,80 @@
+package extractor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+func TestExtractFromHTML(t *testing.T) {
+	var referer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/videos/hls/index.m3u8":
+			referer = r.Header.Get("Referer")
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.0,\nseg0.ts\n#EXT-X-ENDLIST\n"))
+		default:
+			// The page itself is never fetched.
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	page := `<html><head><title>Saved Page</title></head><body>
+<script>var player = {file: "hls/index.m3u8"};</script></body></html>`
+
+	streamInfo, err := New(models.DefaultConfig()).ExtractFromHTML(page, server.URL+"/videos/embed")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if streamInfo.ManifestURL != server.URL+"/videos/hls/index.m3u8" || len(streamInfo.Segments) != 1 {
+		t.Errorf("Expected the relative manifest to be resolved against the base URL, got %s", streamInfo.ManifestURL)
+	}
+	if referer != server.URL+"/videos/embed" {
+		t.Errorf("Expected the base URL as Referer, got %q", referer)
+	}
+	if streamInfo.Title != "Saved Page" {
+		t.Errorf("Expected the page title, got %q", streamInfo.Title)
+	}
+}
+
+func TestExtractFromSavedPageFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/hls/index.m3u8" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("#EXTM3U\n#EXTINF:6.0,\nseg0.ts\n#EXT-X-ENDLIST\n"))
+	}))
+	defer server.Close()
+
+	// Browsers record where a page was saved from, which stands in for a
+	// missing base URL.
+	page := "<!-- saved from url=(0029)" + server.URL + "/embed/1 -->\n" +
+		`<video><source src="/hls/index.m3u8" type="application/x-mpegURL"></video>`
+
+	path := filepath.Join(t.TempDir(), "page.html")
+	if err := os.WriteFile(path, []byte(page), 0644); err != nil {
+		t.Fatalf("Failed to write page: %v", err)
+	}
+
+	streamInfo, err := New(models.DefaultConfig()).Extract(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if streamInfo.ManifestURL != server.URL+"/hls/index.m3u8" || streamInfo.IframeURL != server.URL+"/embed/1" {
+		t.Errorf("Unexpected manifest %s from page %s", streamInfo.ManifestURL, streamInfo.IframeURL)
+	}
+}
+
+func TestExtractFromHTMLRequiresBaseURL(t *testing.T) {
+	_, err := New(models.DefaultConfig()).ExtractFromHTML(`<source src="/hls/index.m3u8">`, "")
+	if err == nil {
+		t.Error("Expected an error without a base URL")
+	}
+}
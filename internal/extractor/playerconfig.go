@@ -0,0 +1,727 @@
+package extractor
+
+import (
+	"html"
+	"math"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// sourceCandidate is a media source declared by a player configuration or
+// an HTML5 <source> tag.
+type sourceCandidate struct {
+	URL     string
+	Label   string
+	Type    string
+	Player  string
+	Default bool
+}
+
+// playerConfig collects the sources and subtitle tracks declared on a page.
+type playerConfig struct {
+	sources   []sourceCandidate
+	subtitles []models.Track
+}
+
+type playerConfigTrigger struct {
+	player  string
+	pattern *regexp.Regexp
+	// sources is set when the matched value is a source list rather than a
+	// full player configuration.
+	sources bool
+}
+
+var playerConfigTriggers = []playerConfigTrigger{
+	{player: "jwplayer", pattern: regexp.MustCompile(`\.setup\(\s*`)},
+	{player: "videojs", pattern: regexp.MustCompile(`videojs\(\s*[^,()]+,\s*`)},
+	{player: "videojs", pattern: regexp.MustCompile(`\.src\(\s*`), sources: true},
+	{player: "clappr", pattern: regexp.MustCompile(`new\s+Clappr\.Player\(\s*`)},
+	{player: "plyr", pattern: regexp.MustCompile(`\.source\s*=\s*`)},
+	{player: "config", pattern: regexp.MustCompile(`["']?\bsources["']?\s*:\s*`), sources: true},
+}
+
+var (
+	htmlSourceTagPattern = regexp.MustCompile(`(?is)<source\b[^>]*>`)
+	htmlTrackTagPattern  = regexp.MustCompile(`(?is)<track\b[^>]*>`)
+	htmlVideoTagPattern  = regexp.MustCompile(`(?is)<video\b[^>]*>`)
+	htmlAttributePattern = regexp.MustCompile(`([\w:-]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+)))?`)
+	labelHeightPattern   = regexp.MustCompile(`(\d{3,4})`)
+)
+
+// configSkipKeys are configuration sections that never hold the main video.
+var configSkipKeys = map[string]bool{
+	"advertising": true,
+	"ads":         true,
+	"vast":        true,
+	"related":     true,
+	"logo":        true,
+	"skin":        true,
+	"thumbnails":  true,
+	"preview":     true,
+}
+
+var adMarkers = []string{"preview", "trailer", "teaser", "advert", "preroll", "/ads/", "/ad/", "vast"}
+
+// parsePlayerConfig locates JW Player, Video.js, Clappr and Plyr setup
+// objects as well as HTML5 <source> and <track> tags and returns the
+// sources and subtitle tracks they declare, in document order.
+func parsePlayerConfig(content, baseURL string) *playerConfig {
+	type match struct {
+		trigger playerConfigTrigger
+		start   int
+	}
+
+	var matches []match
+	for _, trigger := range playerConfigTriggers {
+		for _, loc := range trigger.pattern.FindAllStringIndex(content, -1) {
+			matches = append(matches, match{trigger: trigger, start: loc[1]})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].start < matches[j].start
+	})
+
+	w := &configWalker{
+		config:  &playerConfig{},
+		baseURL: baseURL,
+		seen:    make(map[string]bool),
+	}
+
+	for _, m := range matches {
+		p := &jsParser{src: content, pos: m.start}
+		value, ok := p.parseValue()
+		if !ok {
+			continue
+		}
+
+		w.player = m.trigger.player
+		if m.trigger.sources {
+			w.addSources(value)
+		} else {
+			w.walk(value)
+		}
+	}
+
+	w.player = "html5"
+	w.addHTML5Sources(content)
+
+	return w.config
+}
+
+// mainSource picks the source to download: manifests before progressive
+// files, then the highest labelled quality, with adaptive ("auto" or
+// unlabelled) sources ranked above any fixed quality. Sources that look like
+// previews or ads are ignored and ties keep document order.
+func mainSource(sources []sourceCandidate) (sourceCandidate, bool) {
+	candidates := manifestSources(sources)
+	if len(candidates) == 0 {
+		return sourceCandidate{}, false
+	}
+	return candidates[0], true
+}
+
+// manifestSources returns the manifest sources in the order mainSource
+// prefers them.
+func manifestSources(sources []sourceCandidate) []sourceCandidate {
+	var candidates []sourceCandidate
+	for _, source := range sources {
+		format := source.format()
+		if (format == "hls" || format == "dash") && !source.isAd() {
+			candidates = append(candidates, source)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		hi, hj := candidates[i].labelHeight(), candidates[j].labelHeight()
+		if hi != hj {
+			return hi > hj
+		}
+		return candidates[i].Default && !candidates[j].Default
+	})
+
+	return candidates
+}
+
+// origin describes where on the page the source was declared.
+func (s sourceCandidate) origin() string {
+	switch s.Player {
+	case "html5":
+		return "<source> tag"
+	case "config":
+		return "player config"
+	default:
+		return s.Player + " config"
+	}
+}
+
+// format classifies the source as "hls", "dash", "progressive" or "" when
+// neither its declared type nor its URL tell.
+func (s sourceCandidate) format() string {
+	typ := strings.ToLower(s.Type)
+	ext := ""
+	if u, err := url.Parse(s.URL); err == nil {
+		ext = strings.ToLower(path.Ext(u.Path))
+	}
+
+	switch {
+	case strings.Contains(typ, "mpegurl") || typ == "hls" || ext == ".m3u8":
+		return "hls"
+	case strings.Contains(typ, "dash") || ext == ".mpd":
+		return "dash"
+	case strings.HasPrefix(typ, "video/") || typ == "mp4" || typ == "webm" ||
+		ext == ".mp4" || ext == ".webm" || ext == ".mkv" || ext == ".mov" || ext == ".m4v":
+		return "progressive"
+	}
+	return ""
+}
+
+func (s sourceCandidate) isAd() bool {
+	text := strings.ToLower(s.Label + " " + s.URL)
+	for _, marker := range adMarkers {
+		if strings.Contains(text, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s sourceCandidate) labelHeight() int {
+	label := strings.ToLower(strings.TrimSpace(s.Label))
+	if label == "" || label == "auto" || label == "adaptive" {
+		return math.MaxInt32
+	}
+	if matches := labelHeightPattern.FindStringSubmatch(label); len(matches) > 1 {
+		height, _ := strconv.Atoi(matches[1])
+		return height
+	}
+	switch label {
+	case "4k", "uhd":
+		return 2160
+	case "fhd":
+		return 1080
+	case "hd":
+		return 720
+	case "sd":
+		return 480
+	}
+	return 0
+}
+
+type configWalker struct {
+	config  *playerConfig
+	baseURL string
+	player  string
+	seen    map[string]bool
+}
+
+func (w *configWalker) walk(value interface{}) {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			w.walk(item)
+		}
+	case map[string]interface{}:
+		if w.addSourceObject(v) {
+			return
+		}
+		for _, key := range sortedKeys(v) {
+			child := v[key]
+			switch lower := strings.ToLower(key); {
+			case lower == "sources" || lower == "source":
+				w.addSources(child)
+			case lower == "tracks" || lower == "texttracks" || lower == "captions":
+				w.addTracks(child)
+			case !configSkipKeys[lower]:
+				w.walk(child)
+			}
+		}
+	}
+}
+
+func (w *configWalker) addSources(value interface{}) {
+	switch v := value.(type) {
+	case string:
+		w.addSource(sourceCandidate{URL: v})
+	case []interface{}:
+		for _, item := range v {
+			w.addSources(item)
+		}
+	case map[string]interface{}:
+		w.walk(v)
+	}
+}
+
+// addSourceObject records a {file|src|url: ...} object as a source together
+// with any tracks it carries. It reports whether value was such an object.
+func (w *configWalker) addSourceObject(value map[string]interface{}) bool {
+	sourceURL := firstString(value, "file", "src", "url")
+	if sourceURL == "" {
+		return false
+	}
+
+	w.addSource(sourceCandidate{
+		URL:     sourceURL,
+		Label:   firstString(value, "label", "quality", "res", "size", "height"),
+		Type:    firstString(value, "type"),
+		Default: value["default"] == true,
+	})
+	for _, key := range []string{"tracks", "textTracks", "captions"} {
+		w.addTracks(value[key])
+	}
+	return true
+}
+
+func (w *configWalker) addSource(source sourceCandidate) {
+	source.URL = strings.TrimSpace(source.URL)
+	if source.URL == "" || strings.HasPrefix(strings.ToLower(source.URL), "javascript:") {
+		return
+	}
+	source.URL = resolveURL(w.baseURL, source.URL)
+	source.Player = w.player
+
+	if w.seen[source.URL] {
+		return
+	}
+	w.seen[source.URL] = true
+	w.config.sources = append(w.config.sources, source)
+}
+
+func (w *configWalker) addTracks(value interface{}) {
+	items, ok := value.([]interface{})
+	if !ok {
+		if item, isMap := value.(map[string]interface{}); isMap {
+			items = []interface{}{item}
+		}
+	}
+
+	for _, item := range items {
+		track, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		w.addTrack(
+			firstString(track, "file", "src", "url"),
+			firstString(track, "kind"),
+			firstString(track, "srclang", "language", "lang"),
+			firstString(track, "label", "name"),
+			track["default"] == true,
+		)
+	}
+}
+
+// addTrack records a subtitle track. Tracks without a kind are treated as
+// captions, which is JW Player's default; thumbnails, chapters and metadata
+// tracks are ignored.
+func (w *configWalker) addTrack(trackURL, kind, language, label string, isDefault bool) {
+	kind = strings.ToLower(kind)
+	if trackURL == "" || (kind != "" && kind != "captions" && kind != "subtitles") {
+		return
+	}
+
+	trackURL = resolveURL(w.baseURL, strings.TrimSpace(trackURL))
+	for _, existing := range w.config.subtitles {
+		if existing.URL == trackURL {
+			return
+		}
+	}
+
+	w.config.subtitles = append(w.config.subtitles, models.Track{
+		Type:     "subtitles",
+		Language: language,
+		Name:     label,
+		Default:  isDefault,
+		URL:      trackURL,
+	})
+}
+
+func (w *configWalker) addHTML5Sources(content string) {
+	for _, tag := range htmlVideoTagPattern.FindAllString(content, -1) {
+		if src := htmlAttributes(tag)["src"]; src != "" && !strings.HasPrefix(src, "blob:") {
+			w.addSource(sourceCandidate{URL: src})
+		}
+	}
+
+	for _, tag := range htmlSourceTagPattern.FindAllString(content, -1) {
+		attrs := htmlAttributes(tag)
+		w.addSource(sourceCandidate{
+			URL:   attrs["src"],
+			Label: firstNonEmpty([]string{attrs["label"], attrs["data-quality"], attrs["res"], attrs["size"], attrs["title"]}),
+			Type:  attrs["type"],
+		})
+	}
+
+	for _, tag := range htmlTrackTagPattern.FindAllString(content, -1) {
+		attrs := htmlAttributes(tag)
+		_, isDefault := attrs["default"]
+		w.addTrack(attrs["src"], attrs["kind"], attrs["srclang"], attrs["label"], isDefault)
+	}
+}
+
+func htmlAttributes(tag string) map[string]string {
+	tag = strings.TrimPrefix(tag, "<")
+	if i := strings.IndexAny(tag, " \t\r\n"); i >= 0 {
+		tag = tag[i:]
+	} else {
+		return map[string]string{}
+	}
+
+	attrs := make(map[string]string)
+	for _, matches := range htmlAttributePattern.FindAllStringSubmatch(tag, -1) {
+		attrs[strings.ToLower(matches[1])] = html.UnescapeString(matches[2] + matches[3] + matches[4])
+	}
+	return attrs
+}
+
+func firstString(values map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		switch value := values[key].(type) {
+		case string:
+			if value != "" {
+				return value
+			}
+		case float64:
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsParser reads JavaScript object and array literals leniently: keys may be
+// unquoted, strings may use single quotes or backticks, trailing commas and
+// comments are allowed, and any expression it cannot evaluate (variables,
+// function calls, callbacks) becomes nil.
+type jsParser struct {
+	src string
+	pos int
+}
+
+func (p *jsParser) parseValue() (interface{}, bool) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, false
+	}
+
+	switch p.src[p.pos] {
+	case '{':
+		return p.parseObject()
+	case '[':
+		return p.parseArray()
+	case '"', '\'', '`':
+		s, ok := p.parseString()
+		if !ok {
+			return nil, false
+		}
+		// Join simple 'a' + 'b' concatenations.
+		for {
+			save := p.pos
+			p.skipSpace()
+			if p.pos >= len(p.src) || p.src[p.pos] != '+' {
+				p.pos = save
+				break
+			}
+			p.pos++
+			p.skipSpace()
+			if p.pos >= len(p.src) || !isQuote(p.src[p.pos]) {
+				p.pos = save
+				return p.parseExpressionFrom(save, s)
+			}
+			next, ok := p.parseString()
+			if !ok {
+				return nil, false
+			}
+			s += next
+		}
+		return s, true
+	default:
+		return p.parseExpressionFrom(p.pos, "")
+	}
+}
+
+func (p *jsParser) parseObject() (interface{}, bool) {
+	p.pos++
+	obj := make(map[string]interface{})
+
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, false
+		}
+		if p.src[p.pos] == '}' {
+			p.pos++
+			return obj, true
+		}
+
+		key, ok := p.parseKey()
+		if !ok {
+			return nil, false
+		}
+
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, false
+		}
+
+		switch p.src[p.pos] {
+		case ':':
+			p.pos++
+			value, ok := p.parseValue()
+			if !ok {
+				return nil, false
+			}
+			obj[key] = value
+		case '(':
+			// Method shorthand: name(args) { body }
+			if !p.skipBalanced() {
+				return nil, false
+			}
+			p.skipSpace()
+			if p.pos < len(p.src) && p.src[p.pos] == '{' && !p.skipBalanced() {
+				return nil, false
+			}
+			obj[key] = nil
+		case ',', '}':
+			obj[key] = nil
+		default:
+			return nil, false
+		}
+
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, false
+		}
+		if p.src[p.pos] == ',' {
+			p.pos++
+		} else if p.src[p.pos] != '}' {
+			return nil, false
+		}
+	}
+}
+
+func (p *jsParser) parseArray() (interface{}, bool) {
+	p.pos++
+	var arr []interface{}
+
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, false
+		}
+		if p.src[p.pos] == ']' {
+			p.pos++
+			return arr, true
+		}
+
+		value, ok := p.parseValue()
+		if !ok {
+			return nil, false
+		}
+		arr = append(arr, value)
+
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, false
+		}
+		if p.src[p.pos] == ',' {
+			p.pos++
+		} else if p.src[p.pos] != ']' {
+			return nil, false
+		}
+	}
+}
+
+func (p *jsParser) parseKey() (string, bool) {
+	if isQuote(p.src[p.pos]) {
+		return p.parseString()
+	}
+
+	start := p.pos
+	for p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", false
+	}
+	return p.src[start:p.pos], true
+}
+
+func (p *jsParser) parseString() (string, bool) {
+	quote := p.src[p.pos]
+	p.pos++
+
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return b.String(), true
+		case c == '\\' && p.pos+1 < len(p.src):
+			p.pos++
+			p.writeEscape(&b)
+		case c == '\n' && quote != '`':
+			return "", false
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", false
+}
+
+func (p *jsParser) writeEscape(b *strings.Builder) {
+	c := p.src[p.pos]
+	p.pos++
+
+	switch c {
+	case 'n':
+		b.WriteByte('\n')
+	case 't':
+		b.WriteByte('\t')
+	case 'r':
+		b.WriteByte('\r')
+	case 'b', 'f', 'v', '0':
+	case 'x':
+		if p.pos+2 <= len(p.src) {
+			if v, err := strconv.ParseUint(p.src[p.pos:p.pos+2], 16, 8); err == nil {
+				b.WriteRune(rune(v))
+				p.pos += 2
+				return
+			}
+		}
+		b.WriteByte(c)
+	case 'u':
+		if p.pos+4 <= len(p.src) {
+			if v, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32); err == nil {
+				b.WriteRune(rune(v))
+				p.pos += 4
+				return
+			}
+		}
+		b.WriteByte(c)
+	case '\n':
+	default:
+		b.WriteByte(c)
+	}
+}
+
+// parseExpressionFrom skips an expression starting at start up to the next
+// separator at the same nesting level. Literal booleans and numbers are
+// evaluated; anything else yields nil, or prefix when the expression was a
+// string followed by something other than another string.
+func (p *jsParser) parseExpressionFrom(start int, prefix string) (interface{}, bool) {
+	p.pos = start
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == ',' || c == '}' || c == ']' || c == ')':
+			return p.evaluate(strings.TrimSpace(p.src[start:p.pos]), prefix)
+		case isQuote(c):
+			if _, ok := p.parseString(); !ok {
+				return nil, false
+			}
+		case c == '{' || c == '[' || c == '(':
+			if !p.skipBalanced() {
+				return nil, false
+			}
+		case c == '/' && p.pos+1 < len(p.src) && (p.src[p.pos+1] == '/' || p.src[p.pos+1] == '*'):
+			p.skipSpace()
+		default:
+			p.pos++
+		}
+	}
+	return nil, false
+}
+
+func (p *jsParser) evaluate(expr, prefix string) (interface{}, bool) {
+	if prefix != "" {
+		return prefix, true
+	}
+
+	switch expr {
+	case "":
+		return nil, false
+	case "true", "!0":
+		return true, true
+	case "false", "!1":
+		return false, true
+	}
+	if number, err := strconv.ParseFloat(expr, 64); err == nil {
+		return number, true
+	}
+	return nil, true
+}
+
+// skipBalanced moves past the bracketed block starting at the current
+// position, honouring nested brackets and string literals.
+func (p *jsParser) skipBalanced() bool {
+	depth := 0
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case isQuote(c):
+			if _, ok := p.parseString(); !ok {
+				return false
+			}
+			continue
+		case c == '{' || c == '[' || c == '(':
+			depth++
+		case c == '}' || c == ']' || c == ')':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return true
+			}
+		}
+		p.pos++
+	}
+	return false
+}
+
+func (p *jsParser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		case c == '/' && strings.HasPrefix(p.src[p.pos:], "//"):
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.src)
+				return
+			}
+			p.pos += end + 1
+		case c == '/' && strings.HasPrefix(p.src[p.pos:], "/*"):
+			end := strings.Index(p.src[p.pos+2:], "*/")
+			if end < 0 {
+				p.pos = len(p.src)
+				return
+			}
+			p.pos += end + 4
+		default:
+			return
+		}
+	}
+}
+
+func isQuote(c byte) bool {
+	return c == '"' || c == '\'' || c == '`'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,212 @@
+package extractor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+func TestParsePlayerConfigs(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		expected  string
+		player    string
+		subtitles int
+	}{
+		{
+			name: "jwplayer",
+			content: `<script>
+				var player = jwplayer("player");
+				player.setup({
+					// preview clip shown on hover
+					preview: { file: "https://cdn.example.com/preview/clip.m3u8" },
+					sources: [
+						{ file: "https://cdn.example.com/trailer.m3u8", label: "Trailer" },
+						{ file: "https://cdn.example.com/video/master.m3u8", label: "Auto", type: "hls", "default": true },
+					],
+					tracks: [
+						{ file: "/subs/en.vtt", label: "English", kind: "captions", "default": true },
+						{ file: "/thumbs.vtt", kind: "thumbnails" },
+					],
+					advertising: { client: "vast", schedule: [{ tag: "https://ads.example.com/vast.m3u8" }] },
+					events: { onReady() { console.log('ready'); } },
+				});
+			</script>`,
+			expected:  "https://cdn.example.com/video/master.m3u8",
+			player:    "jwplayer",
+			subtitles: 1,
+		},
+		{
+			name: "videojs",
+			content: `<script>
+				var player = videojs('my-video', {
+					autoplay: false,
+					sources: [
+						{src: '//cdn.example.com/480/index.m3u8', type: 'application/x-mpegURL', label: '480p'},
+						{src: '//cdn.example.com/1080/index.m3u8', type: 'application/x-mpegURL', label: '1080p'},
+					],
+				});
+			</script>`,
+			expected: "https://cdn.example.com/1080/index.m3u8",
+			player:   "videojs",
+		},
+		{
+			name: "clappr",
+			content: `<script>
+				var player = new Clappr.Player({
+					source: "https://cdn.example.com/" + "clappr/stream.mpd",
+					parentId: "#player",
+					plugins: [LevelSelector],
+				});
+			</script>`,
+			expected: "https://cdn.example.com/clappr/stream.mpd",
+			player:   "clappr",
+		},
+		{
+			name: "plyr",
+			content: `<script>
+				const player = new Plyr('#player');
+				player.source = {
+					type: 'video',
+					title: 'Example',
+					sources: [{ src: '/media/720.m3u8', type: 'application/x-mpegURL', size: 720 }],
+					tracks: [{ kind: 'subtitles', label: 'Español', srclang: 'es', src: '/media/es.vtt' }],
+				};
+			</script>`,
+			expected:  "https://player.example.com/media/720.m3u8",
+			player:    "plyr",
+			subtitles: 1,
+		},
+		{
+			name: "html5",
+			content: `<video controls poster="/poster.jpg">
+				<source src="/hls/low.m3u8" type="application/x-mpegURL" label="360p">
+				<source src="/hls/high.m3u8" type="application/x-mpegURL" label="720p">
+				<track kind="subtitles" src="/subs/fr.vtt" srclang="fr" label="Français" default>
+				<track kind="chapters" src="/chapters.vtt">
+			</video>`,
+			expected:  "https://player.example.com/hls/high.m3u8",
+			player:    "html5",
+			subtitles: 1,
+		},
+	}
+
+	for _, test := range tests {
+		config := parsePlayerConfig(test.content, "https://player.example.com/embed/1")
+
+		source, ok := mainSource(config.sources)
+		if !ok {
+			t.Errorf("%s: no main source among %+v", test.name, config.sources)
+			continue
+		}
+		if source.URL != test.expected {
+			t.Errorf("%s: expected main source %s, got %s", test.name, test.expected, source.URL)
+		}
+		if source.Player != test.player {
+			t.Errorf("%s: expected player %s, got %s", test.name, test.player, source.Player)
+		}
+		if len(config.subtitles) != test.subtitles {
+			t.Errorf("%s: expected %d subtitle tracks, got %+v", test.name, test.subtitles, config.subtitles)
+		}
+	}
+}
+
+func TestParsePlayerConfigSubtitleTracks(t *testing.T) {
+	content := `jwplayer("p").setup({file: "/v.m3u8", tracks: [{file: "/subs/en.vtt", label: "English", "default": true}]});`
+
+	config := parsePlayerConfig(content, "https://example.com/embed")
+	if len(config.subtitles) != 1 {
+		t.Fatalf("Expected 1 subtitle track, got %d", len(config.subtitles))
+	}
+
+	track := config.subtitles[0]
+	if track.URL != "https://example.com/subs/en.vtt" || track.Name != "English" || !track.Default || track.Type != "subtitles" {
+		t.Errorf("Unexpected subtitle track: %+v", track)
+	}
+}
+
+func TestFindManifestURLPrefersPlayerConfig(t *testing.T) {
+	content := `<script>
+		var teaser = "https://cdn.example.com/promo/intro.m3u8";
+		jwplayer("player").setup({
+			sources: [{ file: "https://cdn.example.com/main/master.m3u8" }]
+		});
+	</script>`
+
+	e := New(models.DefaultConfig())
+	result, err := e.findManifestURL(content, "https://example.com/embed")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result != "https://cdn.example.com/main/master.m3u8" {
+		t.Errorf("Expected the player config source, got %s", result)
+	}
+}
+
+func TestJSParserLenientLiterals(t *testing.T) {
+	src := `{
+		'single': 'it\'s',
+		unquoted: "a" + 'b',
+		num: 720,
+		flag: !0,
+		callback: function (e) { return e.split(","); },
+		ref: window.config.url,
+		/* comment */ list: [1, 2,],
+	}`
+
+	p := &jsParser{src: src}
+	value, ok := p.parseValue()
+	if !ok {
+		t.Fatal("Failed to parse object literal")
+	}
+
+	obj := value.(map[string]interface{})
+	if obj["single"] != "it's" {
+		t.Errorf("Expected single-quoted string with escape, got %v", obj["single"])
+	}
+	if obj["unquoted"] != "ab" {
+		t.Errorf("Expected concatenated string, got %v", obj["unquoted"])
+	}
+	if obj["num"] != float64(720) {
+		t.Errorf("Expected number, got %v", obj["num"])
+	}
+	if obj["flag"] != true {
+		t.Errorf("Expected !0 to be true, got %v", obj["flag"])
+	}
+	if obj["callback"] != nil || obj["ref"] != nil {
+		t.Errorf("Expected unevaluated expressions to be nil, got %v and %v", obj["callback"], obj["ref"])
+	}
+	if list, ok := obj["list"].([]interface{}); !ok || len(list) != 2 {
+		t.Errorf("Expected two element list, got %v", obj["list"])
+	}
+}
+
+func TestExtractFromIframeSubtitles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/embed":
+			w.Write([]byte(`<script>jwplayer("p").setup({
+				sources: [{file: "/hls/index.m3u8"}],
+				tracks: [{file: "/subs/de.vtt", label: "Deutsch", kind: "subtitles"}]
+			});</script>`))
+		case "/hls/index.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10.0,\nsegment0.ts\n#EXT-X-ENDLIST\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	e := New(models.DefaultConfig())
+	streamInfo, err := e.ExtractFromIframe(server.URL + "/embed")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(streamInfo.Subtitles) != 1 || streamInfo.Subtitles[0].URL != server.URL+"/subs/de.vtt" {
+		t.Errorf("Expected subtitle track from player config, got %+v", streamInfo.Subtitles)
+	}
+}
@@ -0,0 +1,345 @@
+package extractor
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+func isMasterPlaylist(content string) bool {
+	return strings.Contains(content, "#EXT-X-STREAM-INF")
+}
+
+func (e *Extractor) parseMasterPlaylist(content, manifestURL string) ([]models.Variant, error) {
+	lines := strings.Split(content, "\n")
+	var variants []models.Variant
+	var pending *models.Variant
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+			variant := models.Variant{
+				Codecs: attrs["CODECS"],
+			}
+			variant.Bandwidth, _ = strconv.Atoi(attrs["BANDWIDTH"])
+			if variant.Bandwidth == 0 {
+				variant.Bandwidth, _ = strconv.Atoi(attrs["AVERAGE-BANDWIDTH"])
+			}
+			if resolution := attrs["RESOLUTION"]; resolution != "" {
+				fmt.Sscanf(strings.ToLower(resolution), "%dx%d", &variant.Width, &variant.Height)
+			}
+			variant.FrameRate, _ = strconv.ParseFloat(attrs["FRAME-RATE"], 64)
+			variant.Audio = attrs["AUDIO"]
+			variant.Subtitles = attrs["SUBTITLES"]
+			pending = &variant
+		} else if line != "" && !strings.HasPrefix(line, "#") && pending != nil {
+			pending.URL = resolveURL(manifestURL, line)
+			variants = append(variants, *pending)
+			pending = nil
+		}
+	}
+
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("no variants found in master playlist")
+	}
+
+	return variants, nil
+}
+
+// parseAudioRenditions returns the EXT-X-MEDIA TYPE=AUDIO renditions of a
+// master playlist. Renditions without a URI are carried in the variant
+// streams themselves and keep an empty URL.
+func parseAudioRenditions(content, manifestURL string) []models.Track {
+	return parseRenditions(content, manifestURL, "AUDIO")
+}
+
+// parseSubtitleRenditions returns the EXT-X-MEDIA TYPE=SUBTITLES renditions
+// of a master playlist, whose URLs point to segmented WebVTT playlists.
+func parseSubtitleRenditions(content, manifestURL string) []models.Track {
+	return parseRenditions(content, manifestURL, "SUBTITLES")
+}
+
+func parseRenditions(content, manifestURL, mediaType string) []models.Track {
+	var tracks []models.Track
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#EXT-X-MEDIA:") {
+			continue
+		}
+
+		attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-MEDIA:"))
+		if attrs["TYPE"] != mediaType {
+			continue
+		}
+
+		track := models.Track{
+			Type:     strings.ToLower(mediaType),
+			GroupID:  attrs["GROUP-ID"],
+			Language: attrs["LANGUAGE"],
+			Name:     attrs["NAME"],
+			Default:  attrs["DEFAULT"] == "YES",
+		}
+		if attrs["URI"] != "" {
+			track.URL = resolveURL(manifestURL, attrs["URI"])
+			track.Format = "hls"
+		}
+		tracks = append(tracks, track)
+	}
+
+	return tracks
+}
+
+// selectAudioTrack picks the audio rendition for a variant: among the
+// renditions of the variant's group (all of them when it names none), the
+// first whose language or name matches lang, otherwise the default one,
+// otherwise the first. It returns nil when there are no renditions.
+func selectAudioTrack(tracks []models.Track, groupID, lang string) *models.Track {
+	var candidates []models.Track
+	for _, track := range tracks {
+		if groupID == "" || track.GroupID == groupID {
+			candidates = append(candidates, track)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	if lang != "" {
+		for i, track := range candidates {
+			if matchesLanguage(track, lang) {
+				return &candidates[i]
+			}
+		}
+	}
+
+	for i, track := range candidates {
+		if track.Default {
+			return &candidates[i]
+		}
+	}
+	return &candidates[0]
+}
+
+// matchesLanguage compares lang with the track's language tag, so that "en"
+// matches "en-US", or with its name, case-insensitively.
+func matchesLanguage(track models.Track, lang string) bool {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	language := strings.ToLower(track.Language)
+
+	return language == lang ||
+		strings.HasPrefix(language, lang+"-") ||
+		strings.EqualFold(track.Name, lang)
+}
+
+func parseKey(attributeList, baseURL string) (*models.Key, error) {
+	attrs := parseAttributes(attributeList)
+
+	switch method := attrs["METHOD"]; method {
+	case "NONE":
+		return nil, nil
+	case "AES-128":
+		if attrs["URI"] == "" {
+			return nil, fmt.Errorf("AES-128 key without URI")
+		}
+		return &models.Key{
+			Method: method,
+			URI:    resolveURL(baseURL, attrs["URI"]),
+			IV:     attrs["IV"],
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported encryption method: %s", method)
+	}
+}
+
+// parseByteRange parses a BYTERANGE value of the form <length>[@<offset>].
+// When the offset is omitted the sub-range starts at the byte following the
+// previous sub-range of the same resource, passed in as nextOffset.
+func parseByteRange(value string, nextOffset int64) (int64, int64, error) {
+	lengthStr, offsetStr, hasOffset := strings.Cut(strings.TrimSpace(value), "@")
+
+	length, err := strconv.ParseInt(lengthStr, 10, 64)
+	if err != nil || length <= 0 {
+		return 0, 0, fmt.Errorf("invalid byte range: %s", value)
+	}
+
+	offset := nextOffset
+	if hasOffset {
+		offset, err = strconv.ParseInt(offsetStr, 10, 64)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid byte range: %s", value)
+		}
+	}
+
+	return offset, length, nil
+}
+
+// parseAttributes splits an HLS attribute list such as
+// BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2" into its key/value pairs.
+func parseAttributes(list string) map[string]string {
+	attrs := make(map[string]string)
+
+	for len(list) > 0 {
+		eq := strings.IndexByte(list, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(list[:eq])
+		list = list[eq+1:]
+
+		var value string
+		if strings.HasPrefix(list, `"`) {
+			end := strings.IndexByte(list[1:], '"')
+			if end < 0 {
+				value = list[1:]
+				list = ""
+			} else {
+				value = list[1 : end+1]
+				list = list[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(list, ',')
+			if end < 0 {
+				value = list
+				list = ""
+			} else {
+				value = list[:end]
+				list = list[end:]
+			}
+		}
+
+		attrs[key] = value
+		list = strings.TrimPrefix(list, ",")
+	}
+
+	return attrs
+}
+
+// selectVariant picks a variant according to the quality preference, which
+// may be "best", "worst", a height such as "720p", or a maximum bandwidth in
+// bits per second with an optional k/m suffix such as "2500k".
+func selectVariant(variants []models.Variant, quality string) (models.Variant, error) {
+	if len(variants) == 0 {
+		return models.Variant{}, fmt.Errorf("no variants available")
+	}
+
+	sorted := make([]models.Variant, len(variants))
+	copy(sorted, variants)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Bandwidth != sorted[j].Bandwidth {
+			return sorted[i].Bandwidth < sorted[j].Bandwidth
+		}
+		return sorted[i].Height < sorted[j].Height
+	})
+
+	quality = strings.ToLower(strings.TrimSpace(quality))
+
+	switch {
+	case quality == "" || quality == "best":
+		return sorted[len(sorted)-1], nil
+
+	case quality == "worst":
+		return sorted[0], nil
+
+	case strings.HasSuffix(quality, "p"):
+		height, err := strconv.Atoi(strings.TrimSuffix(quality, "p"))
+		if err != nil || height <= 0 {
+			return models.Variant{}, fmt.Errorf("invalid quality: %s", quality)
+		}
+
+		var best *models.Variant
+		for i := range sorted {
+			if sorted[i].Height > 0 && sorted[i].Height <= height {
+				best = &sorted[i]
+			}
+		}
+		if best != nil {
+			return *best, nil
+		}
+		for i := range sorted {
+			if sorted[i].Height > 0 {
+				return sorted[i], nil
+			}
+		}
+		return sorted[0], nil
+
+	default:
+		maxBandwidth, err := parseBandwidth(quality)
+		if err != nil {
+			return models.Variant{}, fmt.Errorf("invalid quality: %s", quality)
+		}
+
+		selected := sorted[0]
+		for _, variant := range sorted {
+			if variant.Bandwidth <= maxBandwidth {
+				selected = variant
+			}
+		}
+		return selected, nil
+	}
+}
+
+func parseBandwidth(s string) (int, error) {
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier = 1000
+		s = strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "m"):
+		multiplier = 1000 * 1000
+		s = strings.TrimSuffix(s, "m")
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid bandwidth: %s", s)
+	}
+
+	return int(value * multiplier), nil
+}
+
+var segmentExtensions = map[string]bool{
+	".ts":   true,
+	".m4s":  true,
+	".mp4":  true,
+	".m4v":  true,
+	".m4a":  true,
+	".cmfv": true,
+	".cmfa": true,
+	".aac":  true,
+	".mp3":  true,
+}
+
+// segmentExtension returns the container extension of a segment URL, falling
+// back to the given default when the path has no recognised media extension.
+func segmentExtension(segmentURL, defaultExtension string) string {
+	u, err := url.Parse(segmentURL)
+	if err != nil {
+		return defaultExtension
+	}
+
+	ext := strings.ToLower(path.Ext(u.Path))
+	if segmentExtensions[ext] {
+		return ext
+	}
+	return defaultExtension
+}
+
+func resolveURL(baseURL, ref string) string {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return ref
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return base.ResolveReference(refURL).String()
+}
@@ -0,0 +1,120 @@
+package extractor
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// SiteExtractor extracts stream information for pages of a specific host.
+// Implementations live in their own files and register themselves from an
+// init function. Extract may return a StreamInfo with only ManifestURL set,
+// in which case the manifest is fetched and parsed by the Extractor.
+type SiteExtractor interface {
+	Name() string
+	Match(pageURL *url.URL) bool
+	Extract(e *Extractor, pageURL string) (*models.StreamInfo, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []SiteExtractor
+)
+
+// Register makes a site extractor available to ExtractFromIframe. It panics
+// if site is nil or an extractor with the same name is already registered.
+func Register(site SiteExtractor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if site == nil {
+		panic("extractor: Register site extractor is nil")
+	}
+
+	for _, registered := range registry {
+		if registered.Name() == site.Name() {
+			panic(fmt.Sprintf("extractor: Register called twice for %s", site.Name()))
+		}
+	}
+
+	registry = append(registry, site)
+}
+
+// Registered returns the registered site extractors sorted by name.
+func Registered() []SiteExtractor {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	sites := make([]SiteExtractor, len(registry))
+	copy(sites, registry)
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i].Name() < sites[j].Name()
+	})
+	return sites
+}
+
+func matchingSiteExtractors(pageURL string) []SiteExtractor {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+
+	var matches []SiteExtractor
+	for _, site := range Registered() {
+		if site.Match(u) {
+			matches = append(matches, site)
+		}
+	}
+	return matches
+}
+
+// FetchPage fetches a page with the extractor's HTTP settings and returns
+// its body and the final URL after redirects. It is intended for use by site
+// extractors.
+func (e *Extractor) FetchPage(pageURL, referer string) (string, string, error) {
+	return e.fetchContent(pageURL, referer)
+}
+
+func (e *Extractor) extractWithSite(site SiteExtractor, iframeURL string) (*models.StreamInfo, error) {
+	streamInfo, err := site.Extract(e, iframeURL)
+	if err != nil {
+		return nil, err
+	}
+	if streamInfo == nil {
+		return nil, fmt.Errorf("no stream information returned")
+	}
+
+	if streamInfo.IframeURL == "" {
+		streamInfo.IframeURL = iframeURL
+	}
+	if len(streamInfo.Chain) == 0 {
+		streamInfo.Chain = []string{iframeURL}
+	}
+
+	if len(streamInfo.Segments) == 0 && streamInfo.ManifestURL == "" {
+		return nil, fmt.Errorf("no manifest URL found")
+	}
+
+	// Headers supplied by the site extractor take precedence over the
+	// Referer and Origin derived from the page it finished on. They are
+	// meant for the site's CDN, so they are dropped again when the manifest
+	// doesn't load rather than sent along with the generic extraction.
+	saved := e.session.SaveHeaders()
+	e.session.SetReferer(streamInfo.Chain[len(streamInfo.Chain)-1])
+	for key, value := range streamInfo.Headers {
+		e.session.SetHeader(key, value)
+	}
+
+	if len(streamInfo.Segments) == 0 {
+		if err := e.loadManifest(streamInfo); err != nil {
+			e.session.RestoreHeaders(saved)
+			return nil, err
+		}
+	}
+	streamInfo.Headers = e.session.Headers()
+
+	return streamInfo, nil
+}
@@ -0,0 +1,113 @@
+package extractor
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// wantSubtitles reports whether subtitle tracks are to be downloaded, either
+// as sidecar files or embedded in the output.
+func (e *Extractor) wantSubtitles() bool {
+	return e.config.SubtitleMode != "" && e.config.SubtitleMode != "none"
+}
+
+// loadSubtitles fills in the segments of the subtitle tracks matching the
+// configured languages (all of them when none are configured). HLS
+// renditions are segmented WebVTT playlists; tracks found in player configs
+// are single files. Tracks that fail to load are skipped rather than failing
+// the whole extraction, since the video is still usable without them.
+func (e *Extractor) loadSubtitles(streamInfo *models.StreamInfo) {
+	var referer string
+	if len(streamInfo.Chain) > 0 {
+		referer = streamInfo.Chain[len(streamInfo.Chain)-1]
+	}
+
+	for i := range streamInfo.Subtitles {
+		track := &streamInfo.Subtitles[i]
+		if track.URL == "" || !subtitleSelected(*track, e.config.SubtitleLangs) {
+			continue
+		}
+
+		prefix := fmt.Sprintf("subs%02d_", i)
+		ext := subtitleExtension(track.URL)
+
+		if track.Format == "hls" || ext == ".m3u8" {
+			if err := e.loadSubtitlePlaylist(track, prefix, referer); err != nil && e.config.Verbose {
+				fmt.Printf("Skipping subtitle track %s %s: %v\n", track.Language, track.Name, err)
+			}
+			continue
+		}
+
+		track.Format = strings.TrimPrefix(ext, ".")
+		track.Segments = []models.Segment{{
+			URL:      track.URL,
+			Filename: prefix + "segment_0000" + ext,
+		}}
+	}
+}
+
+// loadSubtitlePlaylist fetches the media playlist of a subtitle rendition.
+// Its files are prefixed so they don't collide with the media segments.
+func (e *Extractor) loadSubtitlePlaylist(track *models.Track, prefix, referer string) error {
+	content, playlistURL, err := e.fetchContent(track.URL, referer)
+	if err != nil {
+		return err
+	}
+
+	// Ad breaks are kept in subtitle playlists, so that the cues stay on
+	// the timeline the video's AdBreaks refer to; the merger cuts them out.
+	config := *e.config
+	config.SkipAds = false
+	withAds := *e
+	withAds.config = &config
+
+	rendition := &models.StreamInfo{BaseURL: e.getBaseURL(playlistURL)}
+	if err := withAds.parseManifest(content, rendition); err != nil {
+		return err
+	}
+	if len(rendition.InitSegments) > 0 {
+		return fmt.Errorf("fragmented MP4 subtitles are not supported")
+	}
+
+	for i := range rendition.Segments {
+		rendition.Segments[i].Filename = fmt.Sprintf("%ssegment_%04d.vtt", prefix, i)
+	}
+
+	track.Format = "hls"
+	track.Segments = rendition.Segments
+	return nil
+}
+
+// subtitleSelected reports whether a track matches one of langs, or whether
+// langs is empty.
+func subtitleSelected(track models.Track, langs []string) bool {
+	if len(langs) == 0 {
+		return true
+	}
+	for _, lang := range langs {
+		if matchesLanguage(track, lang) {
+			return true
+		}
+	}
+	return false
+}
+
+// subtitleExtension returns ".srt" or ".m3u8" for URLs with those
+// extensions and ".vtt" otherwise.
+func subtitleExtension(subtitleURL string) string {
+	u, err := url.Parse(subtitleURL)
+	if err != nil {
+		return ".vtt"
+	}
+
+	switch ext := strings.ToLower(path.Ext(u.Path)); ext {
+	case ".srt", ".m3u8":
+		return ext
+	default:
+		return ".vtt"
+	}
+}
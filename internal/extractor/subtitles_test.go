@@ -0,0 +1,89 @@
+package extractor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+func TestExtractFromIframeSubtitleRenditions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/embed":
+			w.Write([]byte(`<script>jwplayer("p").setup({
+				sources: [{file: "/hls/master.m3u8"}],
+				tracks: [{file: "/subs/fr.srt", label: "Français", kind: "captions"}]
+			});</script>`))
+		case "/hls/master.m3u8":
+			w.Write([]byte(`#EXTM3U
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",LANGUAGE="en",NAME="English",DEFAULT=YES,URI="subs/en.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",LANGUAGE="de",NAME="Deutsch",URI="subs/de.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="other",LANGUAGE="en",NAME="Other",URI="subs/other.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1000000,SUBTITLES="subs"
+video.m3u8
+`))
+		case "/hls/video.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.0,\nv0.ts\n#EXTINF:6.0,\nv1.ts\n#EXT-X-ENDLIST\n"))
+		case "/hls/subs/en.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.0,\nen0.webvtt\n#EXTINF:6.0,\nen1.webvtt\n#EXT-X-ENDLIST\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	config := models.DefaultConfig()
+	config.SubtitleMode = "sidecar"
+	config.SubtitleLangs = []string{"en", "français"}
+
+	e := New(config)
+	streamInfo, err := e.ExtractFromIframe(server.URL + "/embed")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(streamInfo.Subtitles) != 3 {
+		t.Fatalf("Expected the player track and the variant's two renditions, got %+v", streamInfo.Subtitles)
+	}
+
+	player := streamInfo.Subtitles[0]
+	if player.Format != "srt" || len(player.Segments) != 1 || player.Segments[0].Filename != "subs00_segment_0000.srt" {
+		t.Errorf("Expected the player track as a single SRT file, got %+v", player)
+	}
+
+	english := streamInfo.Subtitles[1]
+	if english.Language != "en" || english.Format != "hls" || len(english.Segments) != 2 {
+		t.Fatalf("Expected two English WebVTT segments, got %+v", english)
+	}
+	if english.Segments[1].URL != server.URL+"/hls/subs/en1.webvtt" || english.Segments[1].Filename != "subs01_segment_0001.vtt" {
+		t.Errorf("Unexpected English segment: %+v", english.Segments[1])
+	}
+
+	if german := streamInfo.Subtitles[2]; len(german.Segments) != 0 {
+		t.Errorf("Expected the unselected German track not to be loaded, got %+v", german)
+	}
+}
+
+func TestExtractFromIframeSubtitlesDisabled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/embed":
+			w.Write([]byte(`<video><source src="/v.m3u8"><track kind="subtitles" src="/en.vtt" srclang="en"></video>`))
+		case "/v.m3u8":
+			w.Write([]byte("#EXTM3U\n#EXTINF:6.0,\nv0.ts\n#EXT-X-ENDLIST\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	streamInfo, err := New(models.DefaultConfig()).ExtractFromIframe(server.URL + "/embed")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(streamInfo.Subtitles) != 1 || len(streamInfo.Subtitles[0].Segments) != 0 {
+		t.Errorf("Expected the subtitle track to be listed but not loaded, got %+v", streamInfo.Subtitles)
+	}
+}
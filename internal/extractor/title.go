@@ -0,0 +1,151 @@
+package extractor
+
+import (
+	"encoding/json"
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	jsonLDPattern       = regexp.MustCompile(`(?is)<script[^>]+type=["']application/ld\+json["'][^>]*>(.*?)</script>`)
+	metaTagPattern      = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	metaPropertyPattern = regexp.MustCompile(`(?i)\b(?:property|name)\s*=\s*["'](og:title|twitter:title)["']`)
+	metaContentPattern  = regexp.MustCompile(`(?is)\bcontent\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	playerTitlePattern  = regexp.MustCompile(`(?i)\b(?:title|videoTitle|video_title)["']?\s*:\s*(?:"([^"]+)"|'([^']+)')`)
+	htmlTitlePattern    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// pageTitles returns the best title found in structured page metadata and,
+// separately, the document <title> which is only used as a last resort since
+// it often carries the site name rather than the video name.
+func pageTitles(content string) (string, string) {
+	primary := jsonLDTitle(content)
+	if primary == "" {
+		primary = openGraphTitle(content)
+	}
+	if primary == "" {
+		primary = playerConfigTitle(content)
+	}
+
+	var fallback string
+	if matches := htmlTitlePattern.FindStringSubmatch(content); len(matches) > 1 {
+		fallback = cleanTitle(matches[1])
+	}
+
+	return primary, fallback
+}
+
+func jsonLDTitle(content string) string {
+	for _, matches := range jsonLDPattern.FindAllStringSubmatch(content, -1) {
+		var data interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(matches[1])), &data); err != nil {
+			continue
+		}
+		if title := findVideoObjectName(data); title != "" {
+			return title
+		}
+	}
+	return ""
+}
+
+func findVideoObjectName(data interface{}) string {
+	switch value := data.(type) {
+	case []interface{}:
+		for _, item := range value {
+			if title := findVideoObjectName(item); title != "" {
+				return title
+			}
+		}
+	case map[string]interface{}:
+		if isVideoObject(value["@type"]) {
+			if name, ok := value["name"].(string); ok && cleanTitle(name) != "" {
+				return cleanTitle(name)
+			}
+		}
+		if graph, ok := value["@graph"]; ok {
+			return findVideoObjectName(graph)
+		}
+	}
+	return ""
+}
+
+func isVideoObject(typ interface{}) bool {
+	switch value := typ.(type) {
+	case string:
+		return value == "VideoObject"
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok && s == "VideoObject" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func openGraphTitle(content string) string {
+	var twitterTitle string
+
+	for _, tag := range metaTagPattern.FindAllString(content, -1) {
+		property := metaPropertyPattern.FindStringSubmatch(tag)
+		if property == nil {
+			continue
+		}
+
+		contentAttr := metaContentPattern.FindStringSubmatch(tag)
+		if contentAttr == nil {
+			continue
+		}
+
+		title := cleanTitle(contentAttr[1] + contentAttr[2])
+		if title == "" {
+			continue
+		}
+
+		if strings.EqualFold(property[1], "og:title") {
+			return title
+		}
+		if twitterTitle == "" {
+			twitterTitle = title
+		}
+	}
+
+	return twitterTitle
+}
+
+func playerConfigTitle(content string) string {
+	matches := playerTitlePattern.FindStringSubmatch(content)
+	if matches == nil {
+		return ""
+	}
+	return cleanTitle(unescapeJSString(matches[1] + matches[2]))
+}
+
+func sessionDataTitle(manifestContent string) string {
+	for _, line := range strings.Split(manifestContent, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#EXT-X-SESSION-DATA:") {
+			continue
+		}
+
+		attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-SESSION-DATA:"))
+		if strings.HasSuffix(strings.ToLower(attrs["DATA-ID"]), ".title") && attrs["VALUE"] != "" {
+			return cleanTitle(attrs["VALUE"])
+		}
+	}
+	return ""
+}
+
+func unescapeJSString(s string) string {
+	var unquoted string
+	if err := json.Unmarshal([]byte(`"`+strings.ReplaceAll(s, `"`, `\"`)+`"`), &unquoted); err == nil {
+		return unquoted
+	}
+	return s
+}
+
+func cleanTitle(title string) string {
+	title = html.UnescapeString(title)
+	return strings.Join(strings.Fields(title), " ")
+}
@@ -0,0 +1,307 @@
+// Package info summarizes extracted stream information for the info command:
+// as text for people, and as JSON with a stable layout for scripts, which
+// unlike models.StreamInfo leaves out the per-segment details.
+package info
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// Summary is what extraction found about a stream.
+type Summary struct {
+	Title           string            `json:"title"`
+	InputURL        string            `json:"input_url"`
+	Pages           []string          `json:"pages"`
+	ManifestURL     string            `json:"manifest_url"`
+	PlaylistURL     string            `json:"playlist_url"`
+	Format          string            `json:"format"`
+	Live            bool              `json:"live"`
+	Quality         string            `json:"quality"`
+	DurationSeconds float64           `json:"duration_seconds"`
+	SegmentCount    int               `json:"segment_count"`
+	Encryption      []Encryption      `json:"encryption"`
+	Variants        []Variant         `json:"variants"`
+	AudioTracks     []Track           `json:"audio_tracks"`
+	Subtitles       []Track           `json:"subtitles"`
+	Sources         []Source          `json:"sources"`
+	Headers         map[string]string `json:"headers"`
+}
+
+// Encryption is a key the segments are encrypted with.
+type Encryption struct {
+	Method string `json:"method"`
+	KeyURI string `json:"key_uri"`
+}
+
+// Variant is a rendition of a master playlist or MPD.
+type Variant struct {
+	Label     string  `json:"label"`
+	Bandwidth int     `json:"bandwidth"`
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	Codecs    string  `json:"codecs"`
+	FrameRate float64 `json:"frame_rate"`
+	URL       string  `json:"url"`
+	Selected  bool    `json:"selected"`
+}
+
+// Track is an audio or subtitle track. Segments is the number of segments
+// that would be downloaded, zero when the track isn't selected.
+type Track struct {
+	Language string `json:"language"`
+	Name     string `json:"name"`
+	GroupID  string `json:"group_id"`
+	Format   string `json:"format"`
+	Default  bool   `json:"default"`
+	Selected bool   `json:"selected"`
+	Segments int    `json:"segments"`
+	URL      string `json:"url"`
+}
+
+// Source is a manifest candidate found on the page.
+type Source struct {
+	URL             string  `json:"url"`
+	Source          string  `json:"source"`
+	Format          string  `json:"format"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Quality         string  `json:"quality"`
+	Error           string  `json:"error"`
+	Selected        bool    `json:"selected"`
+}
+
+// Summarize builds the summary of streamInfo. Lists are never nil, so the
+// JSON always has the same shape.
+func Summarize(streamInfo *models.StreamInfo) *Summary {
+	s := &Summary{
+		Title:           streamInfo.Title,
+		InputURL:        streamInfo.IframeURL,
+		Pages:           append([]string{}, streamInfo.Chain...),
+		ManifestURL:     streamInfo.ManifestURL,
+		PlaylistURL:     streamInfo.PlaylistURL,
+		Format:          streamInfo.Format,
+		Live:            streamInfo.IsLive,
+		Quality:         streamInfo.Quality,
+		DurationSeconds: streamInfo.Duration.Seconds(),
+		SegmentCount:    len(streamInfo.Segments),
+		Encryption:      encryption(streamInfo),
+		Variants:        []Variant{},
+		AudioTracks:     []Track{},
+		Subtitles:       []Track{},
+		Sources:         []Source{},
+		Headers:         make(map[string]string),
+	}
+
+	selected := selectedVariant(streamInfo)
+	for i, v := range streamInfo.Variants {
+		s.Variants = append(s.Variants, Variant{
+			Label:     v.Label(),
+			Bandwidth: v.Bandwidth,
+			Width:     v.Width,
+			Height:    v.Height,
+			Codecs:    v.Codecs,
+			FrameRate: v.FrameRate,
+			URL:       v.URL,
+			Selected:  i == selected,
+		})
+	}
+
+	for _, t := range streamInfo.AudioTracks {
+		track := summarizeTrack(t)
+		if a := streamInfo.Audio; a != nil && a.URL == t.URL && a.GroupID == t.GroupID && a.Name == t.Name {
+			track.Selected = true
+			track.Segments = len(a.Segments)
+		}
+		s.AudioTracks = append(s.AudioTracks, track)
+	}
+	for _, t := range streamInfo.Subtitles {
+		track := summarizeTrack(t)
+		track.Selected = len(t.Segments) > 0
+		s.Subtitles = append(s.Subtitles, track)
+	}
+
+	for _, c := range streamInfo.Candidates {
+		s.Sources = append(s.Sources, Source{
+			URL:             c.URL,
+			Source:          c.Source,
+			Format:          c.Format,
+			DurationSeconds: c.Duration.Seconds(),
+			Quality:         c.Quality,
+			Error:           c.Error,
+			Selected:        c.URL == streamInfo.ManifestURL,
+		})
+	}
+
+	for key, value := range streamInfo.Headers {
+		s.Headers[key] = value
+	}
+
+	return s
+}
+
+func summarizeTrack(t models.Track) Track {
+	return Track{
+		Language: t.Language,
+		Name:     t.Name,
+		GroupID:  t.GroupID,
+		Format:   t.Format,
+		Default:  t.Default,
+		Segments: len(t.Segments),
+		URL:      t.URL,
+	}
+}
+
+// selectedVariant returns the index of the variant whose playlist was
+// loaded, or -1.
+func selectedVariant(streamInfo *models.StreamInfo) int {
+	for i, v := range streamInfo.Variants {
+		if v.URL == streamInfo.PlaylistURL {
+			return i
+		}
+	}
+	for i, v := range streamInfo.Variants {
+		if v.Label() == streamInfo.Quality {
+			return i
+		}
+	}
+	return -1
+}
+
+// encryption returns the distinct keys of the video and audio segments.
+func encryption(streamInfo *models.StreamInfo) []Encryption {
+	segments := append([]models.Segment{}, streamInfo.Segments...)
+	if streamInfo.Audio != nil {
+		segments = append(segments, streamInfo.Audio.Segments...)
+	}
+
+	keys := []Encryption{}
+	seen := make(map[Encryption]bool)
+	for _, segment := range segments {
+		if segment.Key == nil {
+			continue
+		}
+		key := Encryption{Method: segment.Key.Method, KeyURI: segment.Key.URI}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// WriteJSON writes the summary as indented JSON.
+func WriteJSON(w io.Writer, s *Summary) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
+
+// WriteText writes the summary for reading in a terminal.
+func WriteText(w io.Writer, s *Summary) error {
+	var b strings.Builder
+
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "%-14s %s\n", name+":", value)
+		}
+	}
+
+	field("Title", s.Title)
+	field("Input", s.InputURL)
+	for i, page := range s.Pages {
+		field(fmt.Sprintf("Page %d", i+1), page)
+	}
+	field("Manifest", s.ManifestURL)
+	if s.PlaylistURL != s.ManifestURL {
+		field("Playlist", s.PlaylistURL)
+	}
+	field("Format", s.Format)
+	field("Quality", s.Quality)
+
+	duration := roundedDuration(s.DurationSeconds)
+	if s.Live {
+		duration += " (live window)"
+	}
+	field("Duration", duration)
+	field("Segments", fmt.Sprint(s.SegmentCount))
+
+	if len(s.Encryption) == 0 {
+		field("Encryption", "none")
+	}
+	for _, key := range s.Encryption {
+		field("Encryption", strings.TrimSpace(key.Method+" "+key.KeyURI))
+	}
+
+	if len(s.Variants) > 0 {
+		b.WriteString("\nVariants:\n")
+		for _, v := range s.Variants {
+			fmt.Fprintf(&b, "  %s %-6s %8d kbps  %s\n", marker(v.Selected), v.Label, v.Bandwidth/1000, v.Codecs)
+		}
+	}
+
+	writeTracks(&b, "Audio tracks", s.AudioTracks)
+	writeTracks(&b, "Subtitles", s.Subtitles)
+
+	if len(s.Sources) > 0 {
+		b.WriteString("\nSources:\n")
+		for i, source := range s.Sources {
+			details := source.Format
+			if source.Error != "" {
+				details = "error: " + source.Error
+			} else if source.DurationSeconds > 0 {
+				details += ", " + roundedDuration(source.DurationSeconds)
+			}
+			fmt.Fprintf(&b, "  %s %d. %s [%s] %s\n", marker(source.Selected), i+1, source.URL, source.Source, details)
+		}
+	}
+
+	if len(s.Headers) > 0 {
+		b.WriteString("\nHeaders:\n")
+		keys := make([]string, 0, len(s.Headers))
+		for key := range s.Headers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&b, "  %s: %s\n", key, s.Headers[key])
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeTracks(b *strings.Builder, title string, tracks []Track) {
+	if len(tracks) == 0 {
+		return
+	}
+
+	fmt.Fprintf(b, "\n%s:\n", title)
+	for _, t := range tracks {
+		line := strings.TrimSpace(t.Language + " " + t.Name)
+		if t.Default {
+			line += " (default)"
+		}
+		if t.Segments > 0 {
+			line += fmt.Sprintf(", %d segments", t.Segments)
+		}
+		fmt.Fprintf(b, "  %s %s\n", marker(t.Selected), line)
+	}
+}
+
+func roundedDuration(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+}
+
+func marker(selected bool) string {
+	if selected {
+		return "*"
+	}
+	return " "
+}
@@ -0,0 +1,111 @@
+package info
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+func testStreamInfo() *models.StreamInfo {
+	key := &models.Key{Method: "AES-128", URI: "https://cdn.example.com/key"}
+	return &models.StreamInfo{
+		IframeURL:   "https://example.com/embed/1",
+		Chain:       []string{"https://example.com/embed/1", "https://player.example.com/e/1"},
+		ManifestURL: "https://cdn.example.com/master.m3u8",
+		PlaylistURL: "https://cdn.example.com/720.m3u8",
+		Title:       "Movie",
+		Duration:    90 * time.Second,
+		Quality:     "720p",
+		Format:      "hls",
+		Variants: []models.Variant{
+			{URL: "https://cdn.example.com/360.m3u8", Bandwidth: 800000, Height: 360},
+			{URL: "https://cdn.example.com/720.m3u8", Bandwidth: 2500000, Height: 720, Codecs: "avc1.64001f"},
+		},
+		Segments: []models.Segment{
+			{URL: "https://cdn.example.com/s0.ts", Duration: 45, Key: key},
+			{URL: "https://cdn.example.com/s1.ts", Duration: 45, Key: key},
+		},
+		AudioTracks: []models.Track{
+			{GroupID: "aud", Language: "en", Name: "English", Default: true, URL: "https://cdn.example.com/en.m3u8"},
+			{GroupID: "aud", Language: "es", Name: "Español", URL: "https://cdn.example.com/es.m3u8"},
+		},
+		Audio: &models.Track{
+			GroupID: "aud", Language: "en", Name: "English", URL: "https://cdn.example.com/en.m3u8",
+			Segments: []models.Segment{{URL: "https://cdn.example.com/a0.aac", Key: key}},
+		},
+		Subtitles: []models.Track{
+			{Language: "fr", Name: "Français", Format: "vtt"},
+		},
+		Headers: map[string]string{"Referer": "https://player.example.com/e/1"},
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	s := Summarize(testStreamInfo())
+
+	if s.SegmentCount != 2 || s.DurationSeconds != 90 {
+		t.Errorf("Unexpected segments %d and duration %v", s.SegmentCount, s.DurationSeconds)
+	}
+	if len(s.Encryption) != 1 || s.Encryption[0].Method != "AES-128" {
+		t.Errorf("Expected one distinct key, got %+v", s.Encryption)
+	}
+	if len(s.Variants) != 2 || s.Variants[0].Selected || !s.Variants[1].Selected {
+		t.Errorf("Expected the loaded playlist's variant to be selected, got %+v", s.Variants)
+	}
+	if !s.AudioTracks[0].Selected || s.AudioTracks[0].Segments != 1 || s.AudioTracks[1].Selected {
+		t.Errorf("Expected the English track to be selected, got %+v", s.AudioTracks)
+	}
+	if s.Subtitles[0].Selected {
+		t.Errorf("Expected the subtitle track without segments not to be selected")
+	}
+	if s.Headers["Referer"] != "https://player.example.com/e/1" {
+		t.Errorf("Unexpected headers %v", s.Headers)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, Summarize(&models.StreamInfo{})); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	// Scripts rely on lists being present even when empty.
+	for _, key := range []string{"pages", "encryption", "variants", "audio_tracks", "subtitles", "sources"} {
+		if _, ok := decoded[key].([]interface{}); !ok {
+			t.Errorf("Expected %s to be a list, got %v", key, decoded[key])
+		}
+	}
+	if _, ok := decoded["headers"].(map[string]interface{}); !ok {
+		t.Errorf("Expected headers to be an object, got %v", decoded["headers"])
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteText(&buf, Summarize(testStreamInfo())); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	text := buf.String()
+	for _, want := range []string{
+		"Title:         Movie",
+		"Duration:      1m30s",
+		"Segments:      2",
+		"Encryption:    AES-128 https://cdn.example.com/key",
+		"* 720p",
+		"* en English (default), 1 segments",
+		"Referer: https://player.example.com/e/1",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in:\n%s", want, text)
+		}
+	}
+}
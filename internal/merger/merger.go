@@ -3,10 +3,13 @@ package merger
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/yebrai/stream-snatchet/pkg/models"
 )
@@ -26,8 +29,85 @@ func (m *Merger) MergeSegments(streamInfo *models.StreamInfo, segmentsDir, outpu
 		return fmt.Errorf("ffmpeg not available: %w", err)
 	}
 
-	listFile := filepath.Join(segmentsDir, "segments.txt")
-	if err := m.createSegmentsList(streamInfo.Segments, segmentsDir, listFile); err != nil {
+	// Trimming moves ClipStart back to the keyframe the cut really starts
+	// at, which the other tracks and the subtitles then follow.
+	clipped := *streamInfo
+	streamInfo = &clipped
+
+	// Embedded subtitles are muxed in a final pass, so the media is merged
+	// into an intermediate file first.
+	subtitles := subtitleTracks(streamInfo)
+	mediaPath := outputPath
+	if len(subtitles) > 0 && m.config.SubtitleMode == "embed" {
+		mediaPath = filepath.Join(segmentsDir, "media"+filepath.Ext(outputPath))
+		defer os.Remove(mediaPath)
+	}
+
+	if streamInfo.Format == "progressive" {
+		if err := m.mergeProgressive(streamInfo, segmentsDir, mediaPath); err != nil {
+			return err
+		}
+	} else if streamInfo.Audio == nil {
+		if err := m.mergeClippedTrack(streamInfo, streamInfo.Segments, streamInfo.InitSegments, segmentsDir, "segments", mediaPath); err != nil {
+			return err
+		}
+	} else {
+		videoPath := filepath.Join(segmentsDir, "video.mp4")
+		if err := m.mergeClippedTrack(streamInfo, streamInfo.Segments, streamInfo.InitSegments, segmentsDir, "video", videoPath); err != nil {
+			return fmt.Errorf("video track: %w", err)
+		}
+		defer os.Remove(videoPath)
+
+		audioPath := filepath.Join(segmentsDir, "audio.mp4")
+		if err := m.mergeClippedTrack(streamInfo, streamInfo.Audio.Segments, streamInfo.Audio.InitSegments, segmentsDir, "audio", audioPath); err != nil {
+			return fmt.Errorf("audio track: %w", err)
+		}
+		defer os.Remove(audioPath)
+
+		if err := m.muxWithFFmpeg(videoPath, audioPath, mediaPath); err != nil {
+			return fmt.Errorf("failed to mux audio and video: %w", err)
+		}
+	}
+
+	if len(subtitles) > 0 {
+		if err := m.mergeSubtitles(streamInfo, subtitles, segmentsDir, mediaPath, outputPath); err != nil {
+			return fmt.Errorf("subtitles: %w", err)
+		}
+	}
+
+	var downloaded []models.Segment
+	downloaded = append(downloaded, streamInfo.InitSegments...)
+	downloaded = append(downloaded, streamInfo.Segments...)
+	if streamInfo.Audio != nil {
+		downloaded = append(downloaded, streamInfo.Audio.InitSegments...)
+		downloaded = append(downloaded, streamInfo.Audio.Segments...)
+	}
+	for _, subtitle := range subtitles {
+		downloaded = append(downloaded, subtitle.Segments...)
+	}
+	if err := m.cleanupSegments(downloaded, segmentsDir); err != nil && m.config.Verbose {
+		fmt.Printf("Warning: failed to cleanup segments: %v\n", err)
+	}
+
+	return nil
+}
+
+func (m *Merger) mergeTrack(segments, initSegments []models.Segment, segmentsDir, name, outputPath string) error {
+	if runs := models.DiscontinuityRuns(segments); len(runs) > 1 {
+		return m.mergeRuns(runs, initSegments, segmentsDir, name, outputPath)
+	}
+
+	if len(initSegments) > 0 {
+		parts, err := m.assembleFragments(segments, segmentsDir, name)
+		if err != nil {
+			return fmt.Errorf("failed to assemble fragments: %w", err)
+		}
+		defer m.cleanupSegments(parts, segmentsDir)
+		segments = parts
+	}
+
+	listFile := filepath.Join(segmentsDir, name+".txt")
+	if err := m.createSegmentsList(segments, segmentsDir, listFile); err != nil {
 		return fmt.Errorf("failed to create segments list: %w", err)
 	}
 	defer os.Remove(listFile)
@@ -36,8 +116,105 @@ func (m *Merger) MergeSegments(streamInfo *models.StreamInfo, segmentsDir, outpu
 		return fmt.Errorf("failed to merge segments: %w", err)
 	}
 
-	if err := m.cleanupSegments(streamInfo.Segments, segmentsDir); err != nil && m.config.Verbose {
-		fmt.Printf("Warning: failed to cleanup segments: %v\n", err)
+	return nil
+}
+
+// mergeProgressive joins the byte range chunks of a progressive file back
+// into the original file and remuxes it into the output container, trimming
+// it when a time range was requested.
+func (m *Merger) mergeProgressive(streamInfo *models.StreamInfo, segmentsDir, outputPath string) error {
+	if len(streamInfo.Segments) == 0 {
+		return fmt.Errorf("no chunks to merge")
+	}
+
+	joined := models.Segment{Filename: "progressive" + filepath.Ext(streamInfo.Segments[0].Filename)}
+	joinedPath := filepath.Join(segmentsDir, joined.Filename)
+
+	out, err := os.Create(joinedPath)
+	if err != nil {
+		return fmt.Errorf("failed to create joined file: %w", err)
+	}
+	for _, chunk := range streamInfo.Segments {
+		if err := appendFile(out, filepath.Join(segmentsDir, chunk.Filename)); err != nil {
+			out.Close()
+			os.Remove(joinedPath)
+			return fmt.Errorf("failed to join chunk %d: %w", chunk.Index, err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(joinedPath)
+		return fmt.Errorf("failed to join chunks: %w", err)
+	}
+	defer os.Remove(joinedPath)
+
+	return m.mergeClippedTrack(streamInfo, []models.Segment{joined}, nil, segmentsDir, "progressive", outputPath)
+}
+
+// mergeClippedTrack merges a track like mergeTrack and, when the stream was
+// clipped to a time range, trims the result to that range. The
+// track's first segment usually starts before the range, so the cut is made
+// relative to its offset. Streams are copied, so a track with video starts at
+// the keyframe at or before ClipStart; ClipStart is moved back to it so that
+// the tracks merged after it and the subtitles line up with the video. A
+// zero ClipEnd, left by progressive files whose duration isn't known up
+// front, keeps everything after ClipStart.
+func (m *Merger) mergeClippedTrack(streamInfo *models.StreamInfo, segments, initSegments []models.Segment, segmentsDir, name, outputPath string) error {
+	if (streamInfo.ClipStart <= 0 && streamInfo.ClipEnd <= 0) || len(segments) == 0 {
+		return m.mergeTrack(segments, initSegments, segmentsDir, name, outputPath)
+	}
+
+	unclippedPath := filepath.Join(segmentsDir, name+"_unclipped"+filepath.Ext(outputPath))
+	if err := m.mergeTrack(segments, initSegments, segmentsDir, name, unclippedPath); err != nil {
+		return err
+	}
+	defer os.Remove(unclippedPath)
+
+	offset := time.Duration(segments[0].Offset * float64(time.Second))
+	start := streamInfo.ClipStart - offset
+	if start > 0 {
+		keyframe, err := m.keyframeBefore(unclippedPath, start)
+		if err != nil {
+			return fmt.Errorf("failed to find the keyframe before the requested start: %w", err)
+		}
+		start = keyframe
+		streamInfo.ClipStart = offset + keyframe
+	}
+
+	var duration time.Duration
+	if streamInfo.ClipEnd > 0 {
+		duration = streamInfo.ClipEnd - streamInfo.ClipStart
+	}
+	if err := m.trimWithFFmpeg(unclippedPath, outputPath, start, duration); err != nil {
+		return fmt.Errorf("failed to trim to the requested range: %w", err)
+	}
+	return nil
+}
+
+// mergeRuns merges each discontinuity run into its own file first, so that
+// timestamps that reset at a discontinuity only have to be continuous within
+// a run, and then concatenates the runs, which the concat demuxer offsets by
+// the duration of the runs before them.
+func (m *Merger) mergeRuns(runs [][]models.Segment, initSegments []models.Segment, segmentsDir, name, outputPath string) error {
+	var parts []models.Segment
+	defer func() { m.cleanupSegments(parts, segmentsDir) }()
+
+	for i, run := range runs {
+		runName := fmt.Sprintf("%s_run_%03d", name, i)
+		filename := runName + filepath.Ext(outputPath)
+		if err := m.mergeTrack(run, initSegments, segmentsDir, runName, filepath.Join(segmentsDir, filename)); err != nil {
+			return fmt.Errorf("discontinuity %d: %w", run[0].Discontinuity, err)
+		}
+		parts = append(parts, models.Segment{Index: i, Filename: filename})
+	}
+
+	listFile := filepath.Join(segmentsDir, name+".txt")
+	if err := m.createSegmentsList(parts, segmentsDir, listFile); err != nil {
+		return fmt.Errorf("failed to create segments list: %w", err)
+	}
+	defer os.Remove(listFile)
+
+	if err := m.mergeWithFFmpeg(listFile, outputPath); err != nil {
+		return fmt.Errorf("failed to merge discontinuities: %w", err)
 	}
 
 	return nil
@@ -85,6 +262,70 @@ func (m *Merger) createSegmentsList(segments []models.Segment, segmentsDir, list
 	return nil
 }
 
+// assembleFragments joins fragmented MP4 segments with their initialization
+// section into self-contained MP4 parts. A new part is started whenever the
+// EXT-X-MAP changes so that each part carries the init section it was encoded
+// against.
+func (m *Merger) assembleFragments(segments []models.Segment, segmentsDir, name string) ([]models.Segment, error) {
+	var parts []models.Segment
+	var part *os.File
+	currentInit := ""
+
+	closePart := func() error {
+		if part == nil {
+			return nil
+		}
+		err := part.Close()
+		part = nil
+		return err
+	}
+	defer closePart()
+
+	for _, segment := range segments {
+		if part == nil || segment.InitFilename != currentInit {
+			if err := closePart(); err != nil {
+				return nil, err
+			}
+
+			filename := fmt.Sprintf("%s_part_%04d.mp4", name, len(parts))
+			file, err := os.Create(filepath.Join(segmentsDir, filename))
+			if err != nil {
+				return nil, err
+			}
+			part = file
+			currentInit = segment.InitFilename
+			parts = append(parts, models.Segment{Index: len(parts), Filename: filename})
+
+			if currentInit != "" {
+				if err := appendFile(part, filepath.Join(segmentsDir, currentInit)); err != nil {
+					return nil, fmt.Errorf("failed to append init segment: %w", err)
+				}
+			}
+		}
+
+		if err := appendFile(part, filepath.Join(segmentsDir, segment.Filename)); err != nil {
+			return nil, fmt.Errorf("failed to append segment %d: %w", segment.Index, err)
+		}
+	}
+
+	if err := closePart(); err != nil {
+		return nil, err
+	}
+
+	return parts, nil
+}
+
+func appendFile(dst io.Writer, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
 func (m *Merger) mergeWithFFmpeg(listFile, outputPath string) error {
 	if m.config.Verbose {
 		fmt.Printf("Merging segments with ffmpeg...\n")
@@ -101,6 +342,134 @@ func (m *Merger) mergeWithFFmpeg(listFile, outputPath string) error {
 		outputPath,
 	}
 
+	if err := m.runFFmpeg(args); err != nil {
+		return err
+	}
+
+	if m.config.Verbose {
+		fmt.Printf("Successfully merged video to: %s\n", outputPath)
+	}
+
+	return nil
+}
+
+func (m *Merger) muxWithFFmpeg(videoPath, audioPath, outputPath string) error {
+	if m.config.Verbose {
+		fmt.Printf("Muxing audio and video with ffmpeg...\n")
+	}
+
+	args := []string{
+		"-i", videoPath,
+		"-i", audioPath,
+		"-map", "0:v:0",
+		"-map", "1:a:0",
+		"-c", "copy",
+		"-y",
+		outputPath,
+	}
+
+	return m.runFFmpeg(args)
+}
+
+// trimWithFFmpeg cuts duration of media starting at start. Streams are
+// copied rather than re-encoded, which keeps the source codec and quality
+// and needs no encoder, so the cut starts at the keyframe at or before start.
+func (m *Merger) trimWithFFmpeg(inputPath, outputPath string, start, duration time.Duration) error {
+	if m.config.Verbose {
+		fmt.Printf("Trimming %v from %v with ffmpeg...\n", duration, start)
+	}
+
+	return m.runFFmpeg(trimArgs(inputPath, outputPath, start, duration))
+}
+
+// trimArgs returns the ffmpeg arguments cutting duration from start of the
+// input, or everything after start when duration is zero.
+func trimArgs(inputPath, outputPath string, start, duration time.Duration) []string {
+	if start < 0 {
+		start = 0
+	}
+
+	args := []string{
+		"-ss", fmt.Sprintf("%.3f", start.Seconds()),
+		"-i", inputPath,
+	}
+	if duration > 0 {
+		args = append(args, "-t", fmt.Sprintf("%.3f", duration.Seconds()))
+	}
+
+	return append(args,
+		"-map", "0",
+		"-c", "copy",
+		"-avoid_negative_ts", "make_zero",
+		"-y",
+		outputPath,
+	)
+}
+
+// keyframeBefore returns the time of the last video keyframe at or before
+// start, from the beginning of the media, which is where a copied cut at
+// start begins. Media without video, or without ffprobe to look, is taken to
+// start exactly at start.
+func (m *Merger) keyframeBefore(inputPath string, start time.Duration) (time.Duration, error) {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		if m.config.Verbose {
+			fmt.Println("Warning: ffprobe not found, subtitles may run ahead of the trimmed video")
+		}
+		return start, nil
+	}
+
+	output, err := exec.Command("ffprobe", keyframeArgs(inputPath, start)...).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe command failed: %w", err)
+	}
+	return parseKeyframe(string(output), start), nil
+}
+
+// keyframeArgs returns the ffprobe arguments listing the video packets up to
+// a little past start, and the start time of the media their timestamps
+// count from.
+func keyframeArgs(inputPath string, start time.Duration) []string {
+	return []string{
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-read_intervals", fmt.Sprintf("%%+%.3f", (start + time.Second).Seconds()),
+		"-show_entries", "packet=pts_time,flags:format=start_time",
+		"-of", "csv=p=1",
+		inputPath,
+	}
+}
+
+// parseKeyframe finds the last keyframe at or before start in the output of
+// keyframeArgs, or returns start when there is none.
+func parseKeyframe(output string, start time.Duration) time.Duration {
+	var startTime float64
+	var keyframes []float64
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		switch {
+		case fields[0] == "format" && len(fields) > 1:
+			startTime, _ = strconv.ParseFloat(fields[1], 64)
+		case fields[0] == "packet" && len(fields) > 2 && strings.Contains(fields[2], "K"):
+			if pts, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				keyframes = append(keyframes, pts)
+			}
+		}
+	}
+
+	keyframe := time.Duration(-1)
+	for _, pts := range keyframes {
+		t := time.Duration((pts-startTime)*1000+0.5) * time.Millisecond
+		if t <= start && t > keyframe {
+			keyframe = t
+		}
+	}
+	if keyframe < 0 {
+		return start
+	}
+	return keyframe
+}
+
+func (m *Merger) runFFmpeg(args []string) error {
 	cmd := exec.Command("ffmpeg", args...)
 
 	if !m.config.Verbose {
@@ -115,10 +484,6 @@ func (m *Merger) mergeWithFFmpeg(listFile, outputPath string) error {
 		return fmt.Errorf("ffmpeg command failed: %w", err)
 	}
 
-	if m.config.Verbose {
-		fmt.Printf("Successfully merged video to: %s\n", outputPath)
-	}
-
 	return nil
 }
 
@@ -138,6 +503,17 @@ func (m *Merger) GenerateOutputFilename(streamInfo *models.StreamInfo, outputDir
 		title = "video"
 	}
 
+	extension := "mp4"
+	if m.config.OutputFormat == "mkv" {
+		extension = "mkv"
+	}
+
+	return filepath.Join(outputDir, fmt.Sprintf("%s.%s", sanitizeFilename(title), extension))
+}
+
+// sanitizeFilename replaces characters that are not allowed in file names on
+// common file systems and truncates the name to 100 bytes.
+func sanitizeFilename(title string) string {
 	title = strings.ReplaceAll(title, " ", "_")
 	title = strings.ReplaceAll(title, "/", "_")
 	title = strings.ReplaceAll(title, "\\", "_")
@@ -153,5 +529,5 @@ func (m *Merger) GenerateOutputFilename(streamInfo *models.StreamInfo, outputDir
 		title = title[:100]
 	}
 
-	return filepath.Join(outputDir, fmt.Sprintf("%s.mp4", title))
+	return title
 }
@@ -3,7 +3,9 @@ package merger
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/yebrai/stream-snatchet/pkg/models"
 )
@@ -120,3 +122,237 @@ func TestCreateSegmentsList(t *testing.T) {
 		t.Errorf("Segments list content mismatch.\nExpected:\n%s\nGot:\n%s", expectedContent, string(content))
 	}
 }
+
+func TestAssembleFragments(t *testing.T) {
+	config := models.DefaultConfig()
+	merger := New(config)
+
+	tempDir := t.TempDir()
+
+	files := map[string]string{
+		"init_00.mp4":      "INIT0|",
+		"init_01.mp4":      "INIT1|",
+		"segment_0000.m4s": "A",
+		"segment_0001.m4s": "B",
+		"segment_0002.m4s": "C",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	segments := []models.Segment{
+		{Index: 0, Filename: "segment_0000.m4s", InitFilename: "init_00.mp4"},
+		{Index: 1, Filename: "segment_0001.m4s", InitFilename: "init_00.mp4"},
+		{Index: 2, Filename: "segment_0002.m4s", InitFilename: "init_01.mp4"},
+	}
+
+	parts, err := merger.assembleFragments(segments, tempDir, "video")
+	if err != nil {
+		t.Fatalf("assembleFragments failed: %v", err)
+	}
+
+	expected := []string{"INIT0|AB", "INIT1|C"}
+	if len(parts) != len(expected) {
+		t.Fatalf("Expected %d parts, got %d", len(expected), len(parts))
+	}
+
+	for i, part := range parts {
+		content, err := os.ReadFile(filepath.Join(tempDir, part.Filename))
+		if err != nil {
+			t.Fatalf("Failed to read part %d: %v", i, err)
+		}
+
+		if string(content) != expected[i] {
+			t.Errorf("Part %d content = %q, expected %q", i, content, expected[i])
+		}
+	}
+}
+
+func TestGenerateOutputFilenameMKV(t *testing.T) {
+	config := models.DefaultConfig()
+	config.OutputFormat = "mkv"
+
+	result := New(config).GenerateOutputFilename(&models.StreamInfo{Title: "Test Video"}, "/tmp")
+	if result != "/tmp/Test_Video.mkv" {
+		t.Errorf("GenerateOutputFilename() = %s, expected /tmp/Test_Video.mkv", result)
+	}
+}
+
+func TestMergeSubtitlesSidecar(t *testing.T) {
+	config := models.DefaultConfig()
+	config.SubtitleMode = "sidecar"
+	config.SubtitleFormat = "srt"
+	merger := New(config)
+
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"subs00_segment_0000.vtt": "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n00:00:01.000 --> 00:00:02.000\nHello\n",
+		"subs00_segment_0001.vtt": "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:1800000,LOCAL:00:00:00.000\n\n00:00:01.000 --> 00:00:02.000\nWorld\n",
+		"subs01_segment_0000.vtt": "WEBVTT\n\n00:00:03.000 --> 00:00:04.000\nHola\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	tracks := []models.Track{
+		{Language: "en", Segments: []models.Segment{{Filename: "subs00_segment_0000.vtt"}, {Filename: "subs00_segment_0001.vtt"}}},
+		{Name: "Español", Segments: []models.Segment{{Filename: "subs01_segment_0000.vtt"}}},
+	}
+
+	outputPath := filepath.Join(tempDir, "Test_Video.mp4")
+	if err := merger.mergeSubtitles(&models.StreamInfo{}, tracks, tempDir, outputPath, outputPath); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"Test_Video.en.srt":      "1\n00:00:01,000 --> 00:00:02,000\nHello\n\n2\n00:00:11,000 --> 00:00:12,000\nWorld\n",
+		"Test_Video.Español.srt": "1\n00:00:03,000 --> 00:00:04,000\nHola\n",
+	}
+	for name, content := range expected {
+		data, err := os.ReadFile(filepath.Join(tempDir, name))
+		if err != nil {
+			t.Errorf("Expected sidecar file %s: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", name, content, data)
+		}
+	}
+}
+
+func TestMergeSubtitlesSkipsAdBreaks(t *testing.T) {
+	config := models.DefaultConfig()
+	config.SubtitleMode = "sidecar"
+	merger := New(config)
+
+	tempDir := t.TempDir()
+	vtt := "WEBVTT\n\n00:00:05.000 --> 00:00:06.000\nBefore\n\n00:00:35.000 --> 00:00:36.000\nAfter\n"
+	if err := os.WriteFile(filepath.Join(tempDir, "subs00_segment_0000.vtt"), []byte(vtt), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A 20s ad break at 10s was removed from the video, so the second cue
+	// moves back by its length.
+	streamInfo := &models.StreamInfo{AdBreaks: []models.AdBreak{{Start: 10 * time.Second, Duration: 20 * time.Second}}}
+	tracks := []models.Track{{Language: "en", Segments: []models.Segment{{Filename: "subs00_segment_0000.vtt"}}}}
+	outputPath := filepath.Join(tempDir, "Test_Video.mp4")
+	if err := merger.mergeSubtitles(streamInfo, tracks, tempDir, outputPath, outputPath); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "Test_Video.en.vtt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "00:00:15.000 --> 00:00:16.000\nAfter") {
+		t.Errorf("Expected the cue after the break to be shifted, got:\n%s", data)
+	}
+}
+
+func TestMergeSubtitlesClipsProgressiveStart(t *testing.T) {
+	config := models.DefaultConfig()
+	config.SubtitleMode = "sidecar"
+	merger := New(config)
+
+	tempDir := t.TempDir()
+	vtt := "WEBVTT\n\n00:00:05.000 --> 00:00:06.000\nBefore\n\n00:10:05.000 --> 00:10:06.000\nAfter\n"
+	if err := os.WriteFile(filepath.Join(tempDir, "subs00_segment_0000.vtt"), []byte(vtt), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// --start alone leaves ClipEnd at zero for a media file, whose duration
+	// isn't known up front.
+	streamInfo := &models.StreamInfo{Format: "progressive", ClipStart: 10 * time.Minute}
+	tracks := []models.Track{{Language: "en", Segments: []models.Segment{{Filename: "subs00_segment_0000.vtt"}}}}
+	outputPath := filepath.Join(tempDir, "Test_Video.mp4")
+	if err := merger.mergeSubtitles(streamInfo, tracks, tempDir, outputPath, outputPath); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "Test_Video.en.vtt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "Before") || !strings.Contains(string(data), "00:00:05.000 --> 00:00:06.000\nAfter") {
+		t.Errorf("Expected the cues to be shifted to the clip start, got:\n%s", data)
+	}
+}
+
+func TestSidecarPathDuplicates(t *testing.T) {
+	used := make(map[string]bool)
+	track := models.Track{Language: "en"}
+
+	first := sidecarPath("/tmp/video.mp4", track, 0, "vtt", used)
+	second := sidecarPath("/tmp/video.mp4", track, 1, "vtt", used)
+	unnamed := sidecarPath("/tmp/video.mp4", models.Track{}, 2, "vtt", used)
+
+	if first != "/tmp/video.en.vtt" || second != "/tmp/video.en-2.vtt" || unnamed != "/tmp/video.3.vtt" {
+		t.Errorf("Unexpected sidecar paths: %s, %s, %s", first, second, unnamed)
+	}
+}
+
+func TestSubtitleMuxArgs(t *testing.T) {
+	stitched := []stitchedSubtitle{
+		{track: models.Track{Language: "en", Name: "English", Default: true}, path: "subs00.srt"},
+		{track: models.Track{Language: "es"}, path: "subs01.srt"},
+	}
+
+	args := strings.Join(subtitleMuxArgs("media.mkv", stitched, "out.mkv", "srt"), " ")
+	expected := "-i media.mkv -i subs00.srt -i subs01.srt -map 0 -map 1:s:0 -map 2:s:0 -c copy -c:s srt " +
+		"-metadata:s:s:0 language=en -metadata:s:s:0 title=English -disposition:s:0 default " +
+		"-metadata:s:s:1 language=es -y out.mkv"
+	if args != expected {
+		t.Errorf("Expected args:\n%s\ngot:\n%s", expected, args)
+	}
+
+	if args := subtitleMuxArgs("media.mp4", stitched, "out.mp4", "vtt"); !strings.Contains(strings.Join(args, " "), "-c:s mov_text") {
+		t.Errorf("Expected mov_text subtitles in MP4, got %v", args)
+	}
+}
+
+func TestTrimArgs(t *testing.T) {
+	args := strings.Join(trimArgs("in.mp4", "out.mp4", 4500*time.Millisecond, 90*time.Second), " ")
+	expected := "-ss 4.500 -i in.mp4 -t 90.000 -map 0 -c copy -avoid_negative_ts make_zero -y out.mp4"
+	if args != expected {
+		t.Errorf("Expected args:\n%s\ngot:\n%s", expected, args)
+	}
+
+	args = strings.Join(trimArgs("in.mp4", "out.mp4", 30*time.Second, 0), " ")
+	expected = "-ss 30.000 -i in.mp4 -map 0 -c copy -avoid_negative_ts make_zero -y out.mp4"
+	if args != expected {
+		t.Errorf("Expected args without a duration:\n%s\ngot:\n%s", expected, args)
+	}
+}
+
+func TestKeyframeArgs(t *testing.T) {
+	args := strings.Join(keyframeArgs("in.mp4", 4500*time.Millisecond), " ")
+	expected := "-v error -select_streams v:0 -read_intervals %+5.500 -show_entries packet=pts_time,flags:format=start_time -of csv=p=1 in.mp4"
+	if args != expected {
+		t.Errorf("Expected args:\n%s\ngot:\n%s", expected, args)
+	}
+}
+
+func TestParseKeyframe(t *testing.T) {
+	// Timestamps count from the start time of the media, 10s here.
+	output := "packet,10.000000,K__\npacket,10.040000,___\npacket,12.000000,K__\npacket,14.000000,K__\npacket,14.040000,___\nformat,10.000000\n"
+
+	tests := map[time.Duration]time.Duration{
+		3500 * time.Millisecond: 2 * time.Second,
+		4 * time.Second:         4 * time.Second,
+		1 * time.Second:         0,
+	}
+	for start, expected := range tests {
+		if keyframe := parseKeyframe(output, start); keyframe != expected {
+			t.Errorf("parseKeyframe(%v) = %v, expected %v", start, keyframe, expected)
+		}
+	}
+
+	// Without video there are no keyframes, and the cut is exact.
+	if keyframe := parseKeyframe("format,0.000000\n", 3*time.Second); keyframe != 3*time.Second {
+		t.Errorf("Expected the start without keyframes, got %v", keyframe)
+	}
+}
@@ -0,0 +1,185 @@
+package merger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yebrai/stream-snatchet/internal/subtitles"
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// stitchedSubtitle is a subtitle track whose segments have been joined into
+// a single file.
+type stitchedSubtitle struct {
+	track models.Track
+	path  string
+}
+
+// subtitleTracks returns the subtitle tracks that have segments to merge.
+func subtitleTracks(streamInfo *models.StreamInfo) []models.Track {
+	var tracks []models.Track
+	for _, track := range streamInfo.Subtitles {
+		if len(track.Segments) > 0 {
+			tracks = append(tracks, track)
+		}
+	}
+	return tracks
+}
+
+// mergeSubtitles stitches each track's segments into a single WebVTT or SRT
+// file. In "embed" mode the files are muxed as soft subtitles with the media
+// at mediaPath into outputPath; otherwise they are saved next to outputPath
+// as <name>.<language>.<format>. Subtitle tracks are not clipped to a time
+// range when downloading, so their cues are shifted to the clipped media.
+func (m *Merger) mergeSubtitles(streamInfo *models.StreamInfo, tracks []models.Track, segmentsDir, mediaPath, outputPath string) error {
+	format := m.subtitleFormat()
+	embed := m.config.SubtitleMode == "embed"
+
+	var stitched []stitchedSubtitle
+	used := make(map[string]bool)
+
+	for i, track := range tracks {
+		cues, err := m.stitchTrack(track, segmentsDir)
+		if err != nil {
+			return fmt.Errorf("track %s %s: %w", track.Language, track.Name, err)
+		}
+		// Later breaks first, so the positions of earlier ones still hold.
+		for j := len(streamInfo.AdBreaks) - 1; j >= 0; j-- {
+			cues = subtitles.Cut(cues, streamInfo.AdBreaks[j].Start, streamInfo.AdBreaks[j].Duration)
+		}
+		if streamInfo.ClipStart > 0 || streamInfo.ClipEnd > 0 {
+			cues = subtitles.Clip(cues, streamInfo.ClipStart, streamInfo.ClipEnd)
+		}
+
+		var path string
+		if embed {
+			path = filepath.Join(segmentsDir, fmt.Sprintf("subs%02d.%s", i, format))
+			defer os.Remove(path)
+		} else {
+			path = sidecarPath(outputPath, track, i, format, used)
+		}
+
+		if err := writeSubtitles(path, cues, format); err != nil {
+			return err
+		}
+		stitched = append(stitched, stitchedSubtitle{track: track, path: path})
+
+		if m.config.Verbose && !embed {
+			fmt.Printf("Saved subtitles to: %s\n", path)
+		}
+	}
+
+	if !embed {
+		return nil
+	}
+
+	if m.config.Verbose {
+		fmt.Printf("Embedding %d subtitle tracks with ffmpeg...\n", len(stitched))
+	}
+	return m.runFFmpeg(subtitleMuxArgs(mediaPath, stitched, outputPath, format))
+}
+
+func (m *Merger) subtitleFormat() string {
+	if m.config.SubtitleFormat == "srt" {
+		return "srt"
+	}
+	return "vtt"
+}
+
+// stitchTrack reads the downloaded segments of a track and stitches them.
+// Missing segments are skipped like missing media segments are.
+func (m *Merger) stitchTrack(track models.Track, segmentsDir string) ([]subtitles.Cue, error) {
+	var segments [][]byte
+	for _, segment := range track.Segments {
+		data, err := os.ReadFile(filepath.Join(segmentsDir, segment.Filename))
+		if err != nil {
+			if m.config.Verbose {
+				fmt.Printf("Warning: subtitle segment not readable: %v\n", err)
+			}
+			continue
+		}
+		segments = append(segments, data)
+	}
+
+	return subtitles.Stitch(segments)
+}
+
+func writeSubtitles(path string, cues []subtitles.Cue, format string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if format == "srt" {
+		err = subtitles.WriteSRT(file, cues)
+	} else {
+		err = subtitles.WriteWebVTT(file, cues)
+	}
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// sidecarPath names a subtitle file after the output file and the track's
+// language, falling back to its name or position, and numbers duplicates.
+func sidecarPath(outputPath string, track models.Track, index int, format string, used map[string]bool) string {
+	tag := track.Language
+	if tag == "" {
+		tag = sanitizeFilename(track.Name)
+	}
+	if tag == "" {
+		tag = fmt.Sprintf("%d", index+1)
+	}
+
+	base := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+	name := base + "." + tag
+	for n := 2; used[name]; n++ {
+		name = fmt.Sprintf("%s.%s-%d", base, tag, n)
+	}
+	used[name] = true
+
+	return name + "." + format
+}
+
+// subtitleMuxArgs builds the ffmpeg arguments that copy the media streams
+// and add each subtitle file as a soft subtitle stream. MP4 only supports
+// mov_text subtitles; Matroska keeps the subtitle format.
+func subtitleMuxArgs(mediaPath string, stitched []stitchedSubtitle, outputPath, format string) []string {
+	codec := "mov_text"
+	if strings.EqualFold(filepath.Ext(outputPath), ".mkv") {
+		codec = "webvtt"
+		if format == "srt" {
+			codec = "srt"
+		}
+	}
+
+	args := []string{"-i", mediaPath}
+	for _, subtitle := range stitched {
+		args = append(args, "-i", subtitle.path)
+	}
+
+	args = append(args, "-map", "0")
+	for i := range stitched {
+		args = append(args, "-map", fmt.Sprintf("%d:s:0", i+1))
+	}
+	args = append(args, "-c", "copy", "-c:s", codec)
+
+	for i, subtitle := range stitched {
+		if subtitle.track.Language != "" {
+			args = append(args, fmt.Sprintf("-metadata:s:s:%d", i), "language="+subtitle.track.Language)
+		}
+		if subtitle.track.Name != "" {
+			args = append(args, fmt.Sprintf("-metadata:s:s:%d", i), "title="+subtitle.track.Name)
+		}
+		if subtitle.track.Default {
+			args = append(args, fmt.Sprintf("-disposition:s:%d", i), "default")
+		}
+	}
+
+	return append(args, "-y", outputPath)
+}
@@ -0,0 +1,118 @@
+package session
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const httpOnlyPrefix = "#HttpOnly_"
+
+// LoadCookiesFile adds the cookies of a Netscape cookies.txt file, as
+// exported by browsers and used by curl and yt-dlp, to the session's jar.
+// The jar then sends each cookie only to matching domains and paths, and
+// cookies that have already expired are skipped.
+func (s *Session) LoadCookiesFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open cookies file: %w", err)
+	}
+	defer file.Close()
+
+	cookies, err := parseCookiesFile(file, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to parse cookies file %s: %w", path, err)
+	}
+
+	for _, entry := range cookies {
+		s.client.Jar.SetCookies(entry.url, []*http.Cookie{entry.cookie})
+	}
+	return nil
+}
+
+type cookieEntry struct {
+	url    *url.URL
+	cookie *http.Cookie
+}
+
+func parseCookiesFile(r io.Reader, now time.Time) ([]cookieEntry, error) {
+	var entries []cookieEntry
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		if httpOnly {
+			line = strings.TrimPrefix(line, httpOnlyPrefix)
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab-separated fields, got %d", lineNumber, len(fields))
+		}
+
+		domain := fields[0]
+		includeSubdomains := strings.EqualFold(fields[1], "TRUE")
+		path := fields[2]
+		secure := strings.EqualFold(fields[3], "TRUE")
+
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry %q", lineNumber, fields[4])
+		}
+		if expiry > 0 && time.Unix(expiry, 0).Before(now) {
+			continue
+		}
+
+		host := strings.TrimPrefix(domain, ".")
+		if host == "" {
+			return nil, fmt.Errorf("line %d: missing domain", lineNumber)
+		}
+		if path == "" {
+			path = "/"
+		}
+
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    strings.Join(fields[6:], "\t"),
+			Path:     path,
+			Secure:   secure,
+			HttpOnly: httpOnly,
+		}
+		// Domain cookies are marked by a leading dot or the subdomain flag;
+		// without a Domain attribute the jar treats the cookie as host-only.
+		if includeSubdomains || strings.HasPrefix(domain, ".") {
+			cookie.Domain = host
+		}
+		if expiry > 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+		}
+
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+
+		entries = append(entries, cookieEntry{
+			url:    &url.URL{Scheme: scheme, Host: host, Path: path},
+			cookie: cookie,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
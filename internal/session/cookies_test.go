@@ -0,0 +1,75 @@
+package session
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+func TestLoadCookiesFile(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour).Unix()
+	lines := []string{
+		"# Netscape HTTP Cookie File",
+		"",
+		".example.com\tTRUE\t/\tFALSE\t" + strconv.FormatInt(future, 10) + "\tsite\tdomain-wide",
+		"cdn.example.com\tFALSE\t/hls\tFALSE\t0\tcdn\thost-only",
+		"#HttpOnly_.example.com\tTRUE\t/\tFALSE\t" + strconv.FormatInt(future, 10) + "\tsession\thttp-only",
+		".example.com\tTRUE\t/\tTRUE\t" + strconv.FormatInt(future, 10) + "\tsecure\tsecure-only",
+		".example.com\tTRUE\t/\tFALSE\t1000000000\texpired\told",
+	}
+
+	path := filepath.Join(t.TempDir(), "cookies.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write cookies file: %v", err)
+	}
+
+	sess := New(models.DefaultConfig())
+	if err := sess.LoadCookiesFile(path); err != nil {
+		t.Fatalf("LoadCookiesFile failed: %v", err)
+	}
+
+	tests := []struct {
+		url      string
+		expected []string
+	}{
+		{url: "http://www.example.com/", expected: []string{"site", "session"}},
+		{url: "https://www.example.com/", expected: []string{"site", "session", "secure"}},
+		{url: "http://cdn.example.com/hls/index.m3u8", expected: []string{"cdn", "site", "session"}},
+		{url: "http://cdn.example.com/other", expected: []string{"site", "session"}},
+		{url: "http://sub.cdn.example.com/hls/index.m3u8", expected: []string{"site", "session"}},
+		{url: "http://other.org/", expected: nil},
+	}
+
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		var names []string
+		for _, cookie := range sess.Jar().Cookies(u) {
+			names = append(names, cookie.Name)
+		}
+
+		if strings.Join(names, ",") != strings.Join(test.expected, ",") {
+			t.Errorf("Cookies for %s = %v, expected %v", test.url, names, test.expected)
+		}
+	}
+}
+
+func TestLoadCookiesFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.txt")
+	if err := os.WriteFile(path, []byte("example.com\tTRUE\t/\n"), 0644); err != nil {
+		t.Fatalf("Failed to write cookies file: %v", err)
+	}
+
+	sess := New(models.DefaultConfig())
+	if err := sess.LoadCookiesFile(path); err == nil {
+		t.Error("Expected error for malformed cookies file")
+	}
+	if err := sess.LoadCookiesFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("Expected error for missing cookies file")
+	}
+}
@@ -0,0 +1,210 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+// Session holds the HTTP state shared by the extractor and the downloader:
+// one client with a cookie jar, so cookies set while fetching the iframe are
+// sent with manifest, key and segment requests, and the headers learned
+// during extraction, such as the Referer and Origin of the player page.
+//
+// Headers, Referer and Cookie given in the configuration are applied last to
+// every request, so they override anything learned during extraction. The
+// configured proxy, if any, carries every request of the session.
+type Session struct {
+	client *http.Client
+	config *models.Config
+
+	mu          sync.RWMutex
+	headers     map[string]string
+	userHeaders map[string]string
+}
+
+func New(config *models.Config) *Session {
+	// cookiejar.New only fails for invalid options.
+	jar, _ := cookiejar.New(nil)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxyFunc(config.Proxy)
+
+	s := &Session{
+		client: &http.Client{
+			Timeout:   time.Duration(config.TimeoutSeconds) * time.Second,
+			Jar:       jar,
+			Transport: transport,
+		},
+		config:      config,
+		headers:     make(map[string]string),
+		userHeaders: make(map[string]string),
+	}
+
+	if origin := originOf(config.Referer); origin != "" {
+		s.userHeaders["Referer"] = config.Referer
+		s.userHeaders["Origin"] = origin
+	}
+	if config.Cookies != "" {
+		s.userHeaders["Cookie"] = config.Cookies
+	}
+	for key, value := range config.Headers {
+		s.userHeaders[http.CanonicalHeaderKey(key)] = value
+	}
+
+	return s
+}
+
+// Client returns the HTTP client used for all requests of the session.
+func (s *Session) Client() *http.Client {
+	return s.client
+}
+
+// Jar returns the session's cookie jar.
+func (s *Session) Jar() http.CookieJar {
+	return s.client.Jar
+}
+
+// SetHeader sets a header sent with every request of the session.
+func (s *Session) SetHeader(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.headers[http.CanonicalHeaderKey(key)] = value
+}
+
+// Header returns the value of a session header, or "" if it is not set.
+func (s *Session) Header(key string) string {
+	key = http.CanonicalHeaderKey(key)
+	if value, ok := s.userHeaders[key]; ok {
+		return value
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.headers[key]
+}
+
+// Headers returns a copy of the session headers, including the ones given
+// in the configuration.
+func (s *Session) Headers() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	headers := make(map[string]string, len(s.headers)+len(s.userHeaders))
+	for key, value := range s.headers {
+		headers[key] = value
+	}
+	for key, value := range s.userHeaders {
+		headers[key] = value
+	}
+	return headers
+}
+
+// SaveHeaders returns a copy of the headers learned during extraction, for
+// RestoreHeaders to go back to.
+func (s *Session) SaveHeaders() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	headers := make(map[string]string, len(s.headers))
+	for key, value := range s.headers {
+		headers[key] = value
+	}
+	return headers
+}
+
+// RestoreHeaders replaces the learned headers with ones saved by
+// SaveHeaders. The headers given in the configuration are not affected.
+func (s *Session) RestoreHeaders(headers map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.headers = make(map[string]string, len(headers))
+	for key, value := range headers {
+		s.headers[key] = value
+	}
+}
+
+// SetReferer makes pageURL the Referer of subsequent requests and derives
+// the Origin header from it, as a browser playing the page would.
+func (s *Session) SetReferer(pageURL string) {
+	origin := originOf(pageURL)
+	if origin == "" {
+		return
+	}
+
+	s.SetHeader("Referer", pageURL)
+	s.SetHeader("Origin", origin)
+}
+
+// NewRequest creates a GET request carrying the configured User-Agent and
+// the session headers.
+func (s *Session) NewRequest(rawURL string) (*http.Request, error) {
+	return s.NewRequestWithReferer(rawURL, "")
+}
+
+// NewRequestWithReferer is like NewRequest but sends referer instead of the
+// learned Referer. A Referer given in the configuration still wins.
+func (s *Session) NewRequestWithReferer(rawURL, referer string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", s.config.UserAgent)
+	req.Header.Set("Accept", "*/*")
+
+	s.mu.RLock()
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+	s.mu.RUnlock()
+
+	if referer != "" {
+		req.Header.Set("Referer", referer)
+	}
+
+	for key, value := range s.userHeaders {
+		req.Header.Set(key, value)
+	}
+
+	return req, nil
+}
+
+// Do sends a request with the session's client.
+func (s *Session) Do(req *http.Request) (*http.Response, error) {
+	return s.client.Do(req)
+}
+
+// ParseHeader splits a "Key: Value" header line.
+func ParseHeader(line string) (string, string, error) {
+	key, value, found := strings.Cut(line, ":")
+	key = strings.TrimSpace(key)
+	if !found || key == "" || strings.ContainsAny(key, " \t") {
+		return "", "", fmt.Errorf("invalid header %q, expected \"Key: Value\"", line)
+	}
+	return http.CanonicalHeaderKey(key), strings.TrimSpace(value), nil
+}
+
+// ValidateReferer checks that referer is an absolute URL, which the Origin
+// header sent along with it is derived from.
+func ValidateReferer(referer string) error {
+	if originOf(referer) == "" {
+		return fmt.Errorf("invalid referer %q, expected an absolute URL such as https://example.com/page", referer)
+	}
+	return nil
+}
+
+func originOf(pageURL string) string {
+	u, err := url.Parse(pageURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
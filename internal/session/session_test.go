@@ -0,0 +1,159 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yebrai/stream-snatchet/pkg/models"
+)
+
+func TestSetReferer(t *testing.T) {
+	sess := New(models.DefaultConfig())
+	sess.SetReferer("https://player.example.com/embed/42?autoplay=1")
+
+	if referer := sess.Header("Referer"); referer != "https://player.example.com/embed/42?autoplay=1" {
+		t.Errorf("Unexpected Referer: %s", referer)
+	}
+	if origin := sess.Header("origin"); origin != "https://player.example.com" {
+		t.Errorf("Unexpected Origin: %s", origin)
+	}
+
+	sess.SetReferer("not a url")
+	if referer := sess.Header("Referer"); referer != "https://player.example.com/embed/42?autoplay=1" {
+		t.Errorf("Expected invalid referer to be ignored, got %s", referer)
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	config := models.DefaultConfig()
+	sess := New(config)
+	sess.SetHeader("x-token", "secret")
+
+	req, err := sess.NewRequest("https://cdn.example.com/index.m3u8")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if req.Header.Get("User-Agent") != config.UserAgent {
+		t.Errorf("Expected configured User-Agent, got %s", req.Header.Get("User-Agent"))
+	}
+	if req.Header.Get("X-Token") != "secret" {
+		t.Errorf("Expected session header, got %q", req.Header.Get("X-Token"))
+	}
+
+	headers := sess.Headers()
+	headers["X-Token"] = "changed"
+	if sess.Header("X-Token") != "secret" {
+		t.Error("Expected Headers to return a copy")
+	}
+}
+
+func TestCookiesPersistAcrossRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/embed":
+			http.SetCookie(w, &http.Cookie{Name: "cdn_auth", Value: "abc", Path: "/"})
+		case "/segment.ts":
+			if cookie, err := r.Cookie("cdn_auth"); err != nil || cookie.Value != "abc" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+			}
+		}
+	}))
+	defer server.Close()
+
+	sess := New(models.DefaultConfig())
+	for _, path := range []string{"/embed", "/segment.ts"} {
+		req, err := sess.NewRequest(server.URL + path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		resp, err := sess.Do(req)
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", path, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Request to %s returned %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestConfiguredHeadersOverrideLearned(t *testing.T) {
+	config := models.DefaultConfig()
+	config.Referer = "https://site.example.com/watch"
+	config.Cookies = "auth=1"
+	config.Headers = map[string]string{"authorization": "Bearer token"}
+
+	sess := New(config)
+	sess.SetReferer("https://player.example.com/embed")
+
+	req, err := sess.NewRequestWithReferer("https://cdn.example.com/key.bin", "https://player.example.com/nested")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]string{
+		"Referer":       "https://site.example.com/watch",
+		"Origin":        "https://site.example.com",
+		"Cookie":        "auth=1",
+		"Authorization": "Bearer token",
+	}
+	for key, value := range expected {
+		if req.Header.Get(key) != value {
+			t.Errorf("%s = %q, expected %q", key, req.Header.Get(key), value)
+		}
+	}
+
+	if headers := sess.Headers(); headers["Referer"] != "https://site.example.com/watch" {
+		t.Errorf("Expected configured Referer in Headers, got %v", headers)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		line        string
+		key         string
+		value       string
+		expectError bool
+	}{
+		{line: "Authorization: Bearer abc", key: "Authorization", value: "Bearer abc"},
+		{line: "x-api-key:secret", key: "X-Api-Key", value: "secret"},
+		{line: "X-Empty:", key: "X-Empty", value: ""},
+		{line: "X-Url: https://example.com/a", key: "X-Url", value: "https://example.com/a"},
+		{line: "no colon", expectError: true},
+		{line: ": value", expectError: true},
+		{line: "Bad Key: value", expectError: true},
+	}
+
+	for _, test := range tests {
+		key, value, err := ParseHeader(test.line)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("ParseHeader(%q) expected error", test.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseHeader(%q) returned error: %v", test.line, err)
+			continue
+		}
+		if key != test.key || value != test.value {
+			t.Errorf("ParseHeader(%q) = %q, %q, expected %q, %q", test.line, key, value, test.key, test.value)
+		}
+	}
+}
+
+func TestValidateReferer(t *testing.T) {
+	for _, referer := range []string{"https://example.com/embed/1", "http://example.com"} {
+		if err := ValidateReferer(referer); err != nil {
+			t.Errorf("ValidateReferer(%q) returned error: %v", referer, err)
+		}
+	}
+	for _, referer := range []string{"example.com/embed/1", "/embed/1", "https://"} {
+		if err := ValidateReferer(referer); err == nil {
+			t.Errorf("ValidateReferer(%q) expected error", referer)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+// Package subtitles parses WebVTT and SRT subtitles, stitches the segments
+// of an HLS subtitle playlist into a single cue list and writes it back out
+// as WebVTT or SRT.
+package subtitles
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cue is a single subtitle cue.
+type Cue struct {
+	Start    time.Duration
+	End      time.Duration
+	Settings string
+	Text     string
+}
+
+// Document is a parsed WebVTT or SRT file.
+type Document struct {
+	Cues []Cue
+
+	// HasTimestampMap reports whether the file carried an HLS
+	// X-TIMESTAMP-MAP header mapping its cue times (Local) to the MPEG-2
+	// presentation timestamps of the media (MPEGTS, in 90kHz ticks).
+	HasTimestampMap bool
+	MPEGTS          int64
+	Local           time.Duration
+}
+
+const (
+	mpegtsClock    = 90000
+	mpegtsRollover = int64(1) << 33
+)
+
+// Parse parses a WebVTT file. SRT input is accepted too, since its cues
+// only differ in the decimal separator and the numeric identifiers.
+func Parse(data []byte) (*Document, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	doc := &Document{}
+	first := true
+
+	for _, block := range strings.Split(text, "\n\n") {
+		block = strings.Trim(block, "\n")
+		if block == "" {
+			continue
+		}
+
+		lines := strings.Split(block, "\n")
+		header := first && strings.HasPrefix(lines[0], "WEBVTT")
+		first = false
+		if header {
+			for _, line := range lines[1:] {
+				if strings.HasPrefix(line, "X-TIMESTAMP-MAP=") {
+					if err := doc.parseTimestampMap(strings.TrimPrefix(line, "X-TIMESTAMP-MAP=")); err != nil {
+						return nil, err
+					}
+				}
+			}
+			continue
+		}
+
+		// NOTE, STYLE and REGION blocks carry no cues.
+		if strings.HasPrefix(lines[0], "NOTE") || lines[0] == "STYLE" || lines[0] == "REGION" {
+			continue
+		}
+
+		timing := 0
+		if !strings.Contains(lines[0], "-->") {
+			timing = 1
+		}
+		if timing >= len(lines) || !strings.Contains(lines[timing], "-->") {
+			continue
+		}
+
+		cue, err := parseTiming(lines[timing])
+		if err != nil {
+			return nil, err
+		}
+		cue.Text = strings.Join(lines[timing+1:], "\n")
+		doc.Cues = append(doc.Cues, cue)
+	}
+
+	return doc, nil
+}
+
+func (doc *Document) parseTimestampMap(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		key, val, _ := strings.Cut(strings.TrimSpace(part), ":")
+		switch key {
+		case "MPEGTS":
+			mpegts, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid X-TIMESTAMP-MAP: %s", value)
+			}
+			doc.MPEGTS = mpegts
+		case "LOCAL":
+			local, err := parseTimestamp(val)
+			if err != nil {
+				return fmt.Errorf("invalid X-TIMESTAMP-MAP: %s", value)
+			}
+			doc.Local = local
+		}
+	}
+
+	doc.HasTimestampMap = true
+	return nil
+}
+
+func parseTiming(line string) (Cue, error) {
+	start, rest, _ := strings.Cut(line, "-->")
+	rest = strings.TrimSpace(rest)
+	end, settings, _ := strings.Cut(rest, " ")
+
+	var cue Cue
+	var err error
+	if cue.Start, err = parseTimestamp(start); err != nil {
+		return cue, err
+	}
+	if cue.End, err = parseTimestamp(end); err != nil {
+		return cue, err
+	}
+	cue.Settings = strings.TrimSpace(settings)
+	return cue, nil
+}
+
+// parseTimestamp parses hh:mm:ss.ttt or mm:ss.ttt, with either a dot or a
+// comma before the milliseconds.
+func parseTimestamp(value string) (time.Duration, error) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), ",", ".")
+
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp: %q", value)
+	}
+
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp: %q", value)
+	}
+	total := time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+
+	unit := time.Minute
+	for i := len(parts) - 2; i >= 0; i-- {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp: %q", value)
+		}
+		total += time.Duration(n) * unit
+		unit *= 60
+	}
+
+	return total, nil
+}
+
+// Stitch joins the segments of a segmented WebVTT playlist into one cue
+// list. Segments carrying an X-TIMESTAMP-MAP are shifted so their cues line
+// up with the media, relative to the first mapped segment, which is where
+// the merged video starts. Cues repeated in consecutive segments because
+// they span a segment boundary are kept once.
+func Stitch(segments [][]byte) ([]Cue, error) {
+	var cues []Cue
+	seen := make(map[Cue]bool)
+
+	var base time.Duration
+	hasBase := false
+	lastMPEGTS := int64(-1)
+	wraps := int64(0)
+
+	for i, data := range segments {
+		doc, err := Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", i, err)
+		}
+
+		var offset time.Duration
+		if doc.HasTimestampMap {
+			// MPEG-2 timestamps are 33 bits and wrap around every ~26.5h.
+			if lastMPEGTS >= 0 && doc.MPEGTS < lastMPEGTS-mpegtsRollover/2 {
+				wraps++
+			}
+			lastMPEGTS = doc.MPEGTS
+
+			mpegts := doc.MPEGTS + wraps*mpegtsRollover
+			offset = mpegtsDuration(mpegts) - doc.Local
+			if !hasBase {
+				base = offset
+				hasBase = true
+			}
+			offset -= base
+		}
+
+		for _, cue := range doc.Cues {
+			cue.Start += offset
+			cue.End += offset
+			if cue.End <= 0 || seen[cue] {
+				continue
+			}
+			if cue.Start < 0 {
+				cue.Start = 0
+			}
+			seen[cue] = true
+			cues = append(cues, cue)
+		}
+	}
+
+	sort.SliceStable(cues, func(i, j int) bool {
+		return cues[i].Start < cues[j].Start
+	})
+
+	return cues, nil
+}
+
+// Clip returns the cues overlapping [start, end), shifted so that start
+// becomes zero and cut at both ends. A zero end keeps every cue after start.
+func Clip(cues []Cue, start, end time.Duration) []Cue {
+	var clipped []Cue
+	for _, cue := range cues {
+		if cue.End <= start || (end > 0 && cue.Start >= end) {
+			continue
+		}
+		if cue.Start < start {
+			cue.Start = start
+		}
+		if end > 0 && cue.End > end {
+			cue.End = end
+		}
+		cue.Start -= start
+		cue.End -= start
+		clipped = append(clipped, cue)
+	}
+	return clipped
+}
+
+// Cut removes [start, start+duration) from the timeline of cues: cues inside
+// it are dropped, cues overlapping it are shortened and later cues are moved
+// back by duration.
+func Cut(cues []Cue, start, duration time.Duration) []Cue {
+	end := start + duration
+	shift := func(t time.Duration) time.Duration {
+		switch {
+		case t >= end:
+			return t - duration
+		case t > start:
+			return start
+		default:
+			return t
+		}
+	}
+
+	var cut []Cue
+	for _, cue := range cues {
+		cue.Start = shift(cue.Start)
+		cue.End = shift(cue.End)
+		if cue.End > cue.Start {
+			cut = append(cut, cue)
+		}
+	}
+	return cut
+}
+
+// mpegtsDuration converts 90kHz ticks to a duration, in microseconds first
+// so that large timestamps don't overflow.
+func mpegtsDuration(ticks int64) time.Duration {
+	return time.Duration(ticks*int64(time.Second/time.Microsecond)/mpegtsClock) * time.Microsecond
+}
+
+// WriteWebVTT writes cues as a WebVTT file.
+func WriteWebVTT(w io.Writer, cues []Cue) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("WEBVTT\n")
+
+	for _, cue := range cues {
+		fmt.Fprintf(bw, "\n%s --> %s", formatTimestamp(cue.Start, '.'), formatTimestamp(cue.End, '.'))
+		if cue.Settings != "" {
+			bw.WriteString(" " + cue.Settings)
+		}
+		bw.WriteString("\n" + cue.Text + "\n")
+	}
+
+	return bw.Flush()
+}
+
+// WriteSRT writes cues as a SubRip file. Cue settings are dropped and WebVTT
+// markup other than bold, italic and underline is stripped, since players
+// don't understand it in SRT.
+func WriteSRT(w io.Writer, cues []Cue) error {
+	bw := bufio.NewWriter(w)
+
+	for i, cue := range cues {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		fmt.Fprintf(bw, "%d\n%s --> %s\n%s\n", i+1,
+			formatTimestamp(cue.Start, ','), formatTimestamp(cue.End, ','), srtText(cue.Text))
+	}
+
+	return bw.Flush()
+}
+
+var (
+	vttTagPattern = regexp.MustCompile(`</?([a-zA-Z]*)[^>]*>`)
+	srtEntities   = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&nbsp;", " ", "&lrm;", "", "&rlm;", "")
+)
+
+func srtText(text string) string {
+	text = vttTagPattern.ReplaceAllStringFunc(text, func(tag string) string {
+		name := strings.ToLower(vttTagPattern.FindStringSubmatch(tag)[1])
+		switch name {
+		case "b", "i", "u":
+			if strings.HasPrefix(tag, "</") {
+				return "</" + name + ">"
+			}
+			return "<" + name + ">"
+		default:
+			return ""
+		}
+	})
+	return srtEntities.Replace(text)
+}
+
+func formatTimestamp(d time.Duration, separator byte) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", ms/3600000, ms/60000%60, ms/1000%60, separator, ms%1000)
+}
@@ -0,0 +1,199 @@
+package subtitles
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	data := "\xef\xbb\xbfWEBVTT\r\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\r\n\r\n" +
+		"NOTE generated\r\n\r\n" +
+		"intro\r\n00:01.000 --> 00:02.500 line:90%\r\nHello\r\nworld\r\n\r\n" +
+		"01:00:00.000 --> 01:00:01.000\r\n<i>Bye</i>\r\n"
+
+	doc, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !doc.HasTimestampMap || doc.MPEGTS != 900000 || doc.Local != 0 {
+		t.Errorf("Unexpected timestamp map: %+v", doc)
+	}
+
+	expected := []Cue{
+		{Start: time.Second, End: 2500 * time.Millisecond, Settings: "line:90%", Text: "Hello\nworld"},
+		{Start: time.Hour, End: time.Hour + time.Second, Text: "<i>Bye</i>"},
+	}
+	if len(doc.Cues) != len(expected) {
+		t.Fatalf("Expected %d cues, got %+v", len(expected), doc.Cues)
+	}
+	for i, cue := range doc.Cues {
+		if cue != expected[i] {
+			t.Errorf("Cue %d: expected %+v, got %+v", i, expected[i], cue)
+		}
+	}
+}
+
+func TestParseSRT(t *testing.T) {
+	data := "1\n00:00:01,000 --> 00:00:02,000\nOne\n\n2\n00:00:03,250 --> 00:00:04,000\nTwo\n"
+
+	doc, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(doc.Cues) != 2 || doc.Cues[1].Start != 3250*time.Millisecond || doc.Cues[1].Text != "Two" {
+		t.Errorf("Unexpected cues: %+v", doc.Cues)
+	}
+}
+
+func TestStitch(t *testing.T) {
+	segments := [][]byte{
+		// Cue times relative to the segment, mapped to the stream's first PTS.
+		[]byte("WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000\n\n00:00:01.000 --> 00:00:03.000\nfirst\n\n00:00:05.000 --> 00:00:07.000\nspans boundary\n"),
+		// The cue spanning the boundary is repeated in the next segment.
+		[]byte("WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:1440000,LOCAL:00:00:06.000\n\n00:00:05.000 --> 00:00:07.000\nspans boundary\n\n00:00:08.000 --> 00:00:09.000\nsecond\n"),
+		// Empty segment.
+		[]byte("WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:1980000,LOCAL:00:00:12.000\n"),
+	}
+
+	cues, err := Stitch(segments)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []Cue{
+		{Start: 1 * time.Second, End: 3 * time.Second, Text: "first"},
+		{Start: 5 * time.Second, End: 7 * time.Second, Text: "spans boundary"},
+		{Start: 8 * time.Second, End: 9 * time.Second, Text: "second"},
+	}
+	if len(cues) != len(expected) {
+		t.Fatalf("Expected %d cues, got %+v", len(expected), cues)
+	}
+	for i, cue := range cues {
+		if cue != expected[i] {
+			t.Errorf("Cue %d: expected %+v, got %+v", i, expected[i], cue)
+		}
+	}
+}
+
+func TestStitchOffsetsSegments(t *testing.T) {
+	// Each segment's cues start at LOCAL 0 and only the MPEGTS advances.
+	segments := [][]byte{
+		[]byte("WEBVTT\nX-TIMESTAMP-MAP=LOCAL:00:00:00.000,MPEGTS:8589000000\n\n00:00:00.500 --> 00:00:01.000\na\n"),
+		[]byte("WEBVTT\nX-TIMESTAMP-MAP=LOCAL:00:00:00.000,MPEGTS:360000\n\n00:00:00.500 --> 00:00:01.000\nb\n"),
+	}
+
+	cues, err := Stitch(segments)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(cues) != 2 {
+		t.Fatalf("Expected 2 cues, got %+v", cues)
+	}
+
+	// The second segment's MPEGTS wrapped around 2^33.
+	gap := time.Duration(((int64(1)<<33)-8589000000+360000)*1000000/90000) * time.Microsecond
+	if diff := cues[1].Start - cues[0].Start - gap; diff < -time.Millisecond || diff > time.Millisecond {
+		t.Errorf("Expected second cue %v after the first, got %+v", gap, cues)
+	}
+	if cues[0].Start != 500*time.Millisecond {
+		t.Errorf("Expected first cue relative to the first segment, got %v", cues[0].Start)
+	}
+}
+
+func TestWriteWebVTT(t *testing.T) {
+	cues := []Cue{
+		{Start: 1500 * time.Millisecond, End: 2 * time.Second, Settings: "align:start", Text: "Hello"},
+		{Start: time.Hour + 61*time.Second, End: time.Hour + 62*time.Second, Text: "Bye"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteWebVTT(&buf, cues); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "WEBVTT\n\n00:00:01.500 --> 00:00:02.000 align:start\nHello\n\n01:01:01.000 --> 01:01:02.000\nBye\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	doc, err := Parse(buf.Bytes())
+	if err != nil || len(doc.Cues) != 2 || doc.Cues[0] != cues[0] {
+		t.Errorf("Written file doesn't parse back: %v %+v", err, doc)
+	}
+}
+
+func TestWriteSRT(t *testing.T) {
+	cues := []Cue{
+		{Start: 0, End: time.Second, Settings: "line:0", Text: "<c.yellow>Tom</c> &amp; <i.loud>Jerry</i>"},
+		{Start: 2 * time.Second, End: 3 * time.Second, Text: "<v Narrator>The <00:00:02.500>end"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSRT(&buf, cues); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "1\n00:00:00,000 --> 00:00:01,000\nTom & <i>Jerry</i>\n\n2\n00:00:02,000 --> 00:00:03,000\nThe end\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestClip(t *testing.T) {
+	cues := []Cue{
+		{Start: 1 * time.Second, End: 2 * time.Second, Text: "before"},
+		{Start: 9 * time.Second, End: 11 * time.Second, Text: "straddles start"},
+		{Start: 12 * time.Second, End: 13 * time.Second, Text: "inside"},
+		{Start: 19 * time.Second, End: 22 * time.Second, Text: "straddles end"},
+		{Start: 20 * time.Second, End: 21 * time.Second, Text: "after"},
+	}
+
+	clipped := Clip(cues, 10*time.Second, 20*time.Second)
+	expected := []Cue{
+		{Start: 0, End: 1 * time.Second, Text: "straddles start"},
+		{Start: 2 * time.Second, End: 3 * time.Second, Text: "inside"},
+		{Start: 9 * time.Second, End: 10 * time.Second, Text: "straddles end"},
+	}
+	if len(clipped) != len(expected) {
+		t.Fatalf("Expected %d cues, got %+v", len(expected), clipped)
+	}
+	for i, cue := range clipped {
+		if cue != expected[i] {
+			t.Errorf("Cue %d: expected %+v, got %+v", i, expected[i], cue)
+		}
+	}
+
+	// Without an end, everything after start is kept.
+	clipped = Clip(cues, 10*time.Second, 0)
+	if len(clipped) != 4 || clipped[3] != (Cue{Start: 10 * time.Second, End: 11 * time.Second, Text: "after"}) {
+		t.Errorf("Expected the cues after start to be kept, got %+v", clipped)
+	}
+}
+
+func TestCut(t *testing.T) {
+	cues := []Cue{
+		{Start: 1 * time.Second, End: 2 * time.Second, Text: "before"},
+		{Start: 9 * time.Second, End: 11 * time.Second, Text: "straddles start"},
+		{Start: 12 * time.Second, End: 13 * time.Second, Text: "inside"},
+		{Start: 19 * time.Second, End: 22 * time.Second, Text: "straddles end"},
+		{Start: 25 * time.Second, End: 26 * time.Second, Text: "after"},
+	}
+
+	cut := Cut(cues, 10*time.Second, 10*time.Second)
+	expected := []Cue{
+		{Start: 1 * time.Second, End: 2 * time.Second, Text: "before"},
+		{Start: 9 * time.Second, End: 10 * time.Second, Text: "straddles start"},
+		{Start: 10 * time.Second, End: 12 * time.Second, Text: "straddles end"},
+		{Start: 15 * time.Second, End: 16 * time.Second, Text: "after"},
+	}
+	if len(cut) != len(expected) {
+		t.Fatalf("Expected %d cues, got %+v", len(expected), cut)
+	}
+	for i, cue := range cut {
+		if cue != expected[i] {
+			t.Errorf("Cue %d: expected %+v, got %+v", i, expected[i], cue)
+		}
+	}
+}
@@ -1,26 +1,149 @@
 package models
 
 import (
+	"fmt"
 	"sync"
 	"time"
 )
 
 type StreamInfo struct {
 	IframeURL   string
+	Chain       []string
 	ManifestURL string
+	PlaylistURL string
 	BaseURL     string
 	Title       string
 	Duration    time.Duration
 	Quality     string
-	Segments    []Segment
-	Headers     map[string]string
+	Format      string
+
+	IsLive         bool
+	TargetDuration time.Duration
+
+	// ClipStart and ClipEnd are the requested time range, relative to the
+	// start of the stream, after segments outside it were dropped. A zero
+	// ClipEnd means the end of the stream.
+	ClipStart time.Duration
+	ClipEnd   time.Duration
+
+	// AdBreaks are the ad breaks --skip-ads removed, positioned on the
+	// timeline of the playlist before they were removed, which subtitle
+	// tracks still follow.
+	AdBreaks []AdBreak
+
+	// Candidates are all the manifests the pages offered, when there was
+	// more than one; ManifestURL is the one that was picked.
+	Candidates []Candidate
+
+	Variants     []Variant
+	Segments     []Segment
+	InitSegments []Segment
+	AudioTracks  []Track
+	Audio        *Track
+	Subtitles    []Track
+	Headers      map[string]string
+}
+
+type Track struct {
+	Type         string
+	GroupID      string
+	Language     string
+	Name         string
+	Default      bool
+	URL          string
+	Format       string
+	Bandwidth    int
+	Codecs       string
+	Segments     []Segment
+	InitSegments []Segment
+}
+
+// AdBreak is a stretch of a stream that was left out of the download.
+type AdBreak struct {
+	Start    time.Duration
+	Duration time.Duration
+}
+
+// Candidate is a manifest found while extracting, with where it was found
+// and what probing it revealed.
+type Candidate struct {
+	URL    string
+	Source string // how it was found, e.g. "jwplayer config", "pattern"
+	Page   string // page it was found on
+
+	// Set by probing the manifest. Error holds why probing failed.
+	Format    string
+	Duration  time.Duration
+	Quality   string
+	Height    int
+	Bandwidth int
+	Error     string
+}
+
+type Variant struct {
+	URL       string
+	Bandwidth int
+	Width     int
+	Height    int
+	Codecs    string
+	FrameRate float64
+	Audio     string
+	Subtitles string
+}
+
+func (v Variant) Label() string {
+	switch {
+	case v.Height > 0:
+		return fmt.Sprintf("%dp", v.Height)
+	case v.Bandwidth > 0:
+		return fmt.Sprintf("%dk", v.Bandwidth/1000)
+	default:
+		return "unknown"
+	}
 }
 
 type Segment struct {
 	URL      string
 	Index    int
+	Sequence int
 	Duration float64
 	Filename string
+	Key      *Key
+
+	// Offset is the start of the segment in seconds from the start of the
+	// playlist, the sum of the EXTINF durations before it.
+	Offset          float64
+	ProgramDateTime time.Time
+
+	ByteOffset int64
+	ByteLength int64
+
+	InitFilename string
+
+	// Discontinuity is the discontinuity sequence number of the segment;
+	// timestamps may reset between segments with different numbers.
+	Discontinuity int
+	// Ad marks segments inside an ad break signalled by the playlist.
+	Ad bool
+}
+
+// DiscontinuityRuns splits segments into runs of consecutive segments with
+// the same discontinuity sequence number.
+func DiscontinuityRuns(segments []Segment) [][]Segment {
+	var runs [][]Segment
+	for i, segment := range segments {
+		if i == 0 || segment.Discontinuity != segments[i-1].Discontinuity {
+			runs = append(runs, nil)
+		}
+		runs[len(runs)-1] = append(runs[len(runs)-1], segment)
+	}
+	return runs
+}
+
+type Key struct {
+	Method string
+	URI    string
+	IV     string
 }
 
 type DownloadProgress struct {
@@ -49,11 +172,30 @@ func (dp *DownloadProgress) GetProgress() (int, int, string) {
 
 type Config struct {
 	OutputDir      string
+	Title          string
 	Quality        string
+	AudioLang      string
+	MaxIframeDepth int
 	MaxConcurrency int
 	RetryAttempts  int
 	TimeoutSeconds int
 	UserAgent      string
+	RecordDuration time.Duration
+	SkipAds        bool
+	SourceIndex    int
+	ClipStart      string
+	ClipEnd        string
+	SubtitleMode   string
+	SubtitleLangs  []string
+	SubtitleFormat string
+	OutputFormat   string
+	Headers        map[string]string
+	Referer        string
+	Cookies        string
+	CookiesFile    string
+	Proxy          string
+	BaseURL        string
+	DumpDir        string
 	EnableGUI      bool
 	Verbose        bool
 }
@@ -62,10 +204,14 @@ func DefaultConfig() *Config {
 	return &Config{
 		OutputDir:      "./downloads",
 		Quality:        "best",
+		MaxIframeDepth: 3,
 		MaxConcurrency: 5,
 		RetryAttempts:  3,
 		TimeoutSeconds: 30,
 		UserAgent:      "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36",
+		SubtitleMode:   "none",
+		SubtitleFormat: "vtt",
+		OutputFormat:   "mp4",
 		EnableGUI:      false,
 		Verbose:        false,
 	}
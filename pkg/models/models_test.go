@@ -0,0 +1,25 @@
+package models
+
+import "testing"
+
+func TestDiscontinuityRuns(t *testing.T) {
+	segments := []Segment{
+		{Index: 0, Discontinuity: 3},
+		{Index: 1, Discontinuity: 3},
+		{Index: 2, Discontinuity: 5},
+		{Index: 3, Discontinuity: 6},
+		{Index: 4, Discontinuity: 6},
+	}
+
+	runs := DiscontinuityRuns(segments)
+	if len(runs) != 3 || len(runs[0]) != 2 || len(runs[1]) != 1 || len(runs[2]) != 2 {
+		t.Fatalf("Unexpected runs: %+v", runs)
+	}
+	if runs[2][0].Index != 3 {
+		t.Errorf("Expected the last run to start at segment 3, got %d", runs[2][0].Index)
+	}
+
+	if runs := DiscontinuityRuns(segments[:2]); len(runs) != 1 {
+		t.Errorf("Expected a single run without discontinuities, got %d", len(runs))
+	}
+}